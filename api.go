@@ -2,12 +2,16 @@ package main
 
 import (
 	"embed"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -18,6 +22,14 @@ type apiResponse struct {
 	Data    any    `json:"data,omitempty"`
 }
 
+// wsMessage is a client-to-server message on /ws. Overlays send
+// {"type":"progress","video_id":"...","elapsed":12.5} while playing.
+type wsMessage struct {
+	Type    string  `json:"type"`
+	VideoID string  `json:"video_id"`
+	Elapsed float64 `json:"elapsed"`
+}
+
 type Hub struct {
 	mu          sync.Mutex
 	conns       map[*websocket.Conn]struct{}
@@ -25,6 +37,7 @@ type Hub struct {
 	overlayMode string
 	modeMu      sync.RWMutex
 	moderation  *ModerationQueue
+	quota       *QuotaTracker
 }
 
 func newHub() *Hub {
@@ -39,6 +52,10 @@ func (h *Hub) setModeration(mod *ModerationQueue) {
 	h.moderation = mod
 }
 
+func (h *Hub) setQuota(q *QuotaTracker) {
+	h.quota = q
+}
+
 func (h *Hub) setOverlayMode(mode string) {
 	h.modeMu.Lock()
 	h.overlayMode = mode
@@ -56,6 +73,10 @@ func (h *Hub) send(st PlayerState) {
 	if h.moderation != nil {
 		st.PendingModeration = h.moderation.list()
 	}
+	if h.quota != nil {
+		q := h.quota.status()
+		st.Quota = &q
+	}
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	for c := range h.conns {
@@ -69,14 +90,16 @@ func (h *Hub) send(st PlayerState) {
 type Server struct {
 	p           *Player
 	hub         *Hub
-	yt          *YouTubeClient
+	yt          MetadataProvider
+	cache       *Cache
+	sessions    *SessionManager
 	donationOn  bool
 	moderation  *ModerationQueue
 	staticFiles embed.FS
 }
 
-func newServer(p *Player, hub *Hub, yt *YouTubeClient, donationOn bool, mod *ModerationQueue, static embed.FS) *Server {
-	return &Server{p: p, hub: hub, yt: yt, donationOn: donationOn, moderation: mod, staticFiles: static}
+func newServer(p *Player, hub *Hub, yt MetadataProvider, c *Cache, sessions *SessionManager, donationOn bool, mod *ModerationQueue, static embed.FS) *Server {
+	return &Server{p: p, hub: hub, yt: yt, cache: c, sessions: sessions, donationOn: donationOn, moderation: mod, staticFiles: static}
 }
 
 func (s *Server) register(mux *http.ServeMux) {
@@ -89,9 +112,21 @@ func (s *Server) register(mux *http.ServeMux) {
 		"/api/next":             s.handleNext,
 		"/api/previous":         s.handlePrevious,
 		"/api/status":           s.handleStatus,
+		"/api/seek":             s.handleSeek,
 		"/api/queue":            s.handleQueue,
 		"/api/nowplaying":       s.handleNowPlaying,
+		"/api/history":          s.handleHistory,
+		"/api/history/export":   s.handleHistoryExport,
+		"/api/session":          s.handleSession,
+		"/api/stats":            s.handleStats,
+		"/api/quota":            s.handleQuota,
+		"/api/cache":            s.handleCache,
+		"/api/search":           s.handleSearch,
+		"/api/session/start":    s.handleSessionStart,
+		"/api/session/end":      s.handleSessionEnd,
 		"/api/remove":           s.handleRemove,
+		"/api/queue/move":       s.handleQueueMove,
+		"/api/queue/order":      s.handleQueueOrder,
 		"/api/clear":            s.handleClear,
 		"/api/remove-played":    s.handleRemovePlayed,
 		"/api/playlist/set":     s.handlePlaylistSet,
@@ -158,16 +193,45 @@ func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 		by = "User"
 	}
 	paid := r.URL.Query().Get("paid") == "true"
+	amount := 0
+	if paid {
+		amount, _ = strconv.Atoi(r.URL.Query().Get("amount"))
+	}
 	if rawURL == "" {
 		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Missing video URL"})
 		return
 	}
-	vid := extractVideoID(rawURL)
-	if vid == "" {
+	ref := extractVideoRef(rawURL)
+	if ref.ID == "" {
 		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Invalid YouTube URL"})
 		return
 	}
-	if err := s.p.validateAndAdd(vid, by, paid); err != nil {
+	// Explicit offsets override the ones in the link.
+	if v := r.URL.Query().Get("start"); v != "" {
+		sec, ok := parseOffset(v)
+		if !ok {
+			reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Invalid start offset"})
+			return
+		}
+		ref.StartSec = sec
+	}
+	if v := r.URL.Query().Get("end"); v != "" {
+		sec, ok := parseOffset(v)
+		if !ok {
+			reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Invalid end offset"})
+			return
+		}
+		ref.EndSec = sec
+	}
+	if err := s.p.validateAndAdd(r.Context(), ref, by, paid, amount); err != nil {
+		if errors.Is(err, errUserQueueLimit) || errors.Is(err, errUserRequestLimit) {
+			reply(w, http.StatusTooManyRequests, apiResponse{Success: false, Message: err.Error(), Data: map[string]any{"reason": "user_limit"}})
+			return
+		}
+		if errors.Is(err, errYouTubeUnavailable) {
+			reply(w, http.StatusServiceUnavailable, apiResponse{Success: false, Message: err.Error(), Data: map[string]any{"reason": "youtube_unavailable"}})
+			return
+		}
 		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
 		return
 	}
@@ -205,7 +269,14 @@ func (s *Server) handleNext(w http.ResponseWriter, r *http.Request) {
 	if !requirePost(w, r) {
 		return
 	}
-	s.p.next()
+	outcome := outcomeSkipped
+	switch r.URL.Query().Get("reason") {
+	case "ended":
+		outcome = outcomeCompleted
+	case "error":
+		outcome = outcomeError
+	}
+	s.p.next(outcome)
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Skipped to next track"})
 }
 
@@ -220,8 +291,29 @@ func (s *Server) handlePrevious(w http.ResponseWriter, r *http.Request) {
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Returned to previous track"})
 }
 
+func (s *Server) handleSeek(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	sec, err := strconv.ParseFloat(r.URL.Query().Get("sec"), 64)
+	if err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Invalid sec parameter"})
+		return
+	}
+	if err := s.p.seek(sec); err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Seeked", Data: map[string]any{"elapsed": sec}})
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	reply(w, http.StatusOK, apiResponse{Success: true, Data: s.p.status()})
+	st := s.p.status()
+	if yc, ok := s.yt.(*YouTubeClient); ok {
+		st["api_keys"] = yc.keyHealth()
+		st["youtube_api"] = yc.breakerStatus()
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: st})
 }
 
 func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
@@ -238,6 +330,163 @@ func (s *Server) handleNowPlaying(w http.ResponseWriter, r *http.Request) {
 	reply(w, http.StatusOK, apiResponse{Success: true, Data: s.p.nowPlaying()})
 }
 
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	f, err := parseHistoryFilter(r)
+	if err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	items, total := s.cache.queryHistory(f, (page-1)*limit, limit)
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: map[string]any{
+		"items": items,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	}})
+}
+
+func (s *Server) handleHistoryExport(w http.ResponseWriter, r *http.Request) {
+	f, err := parseHistoryFilter(r)
+	if err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	items, _ := s.cache.queryHistory(f, 0, 0)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.json"`)
+		json.NewEncoder(w).Encode(items)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"started_at", "ended_at", "outcome", "video_id", "title", "clean_title", "featured", "duration_sec", "added_by", "is_paid", "amount", "source"})
+		titles := s.p.cfg.get().titleNormalizer()
+		for _, e := range items {
+			tt := titles.normalize(e.Title, "")
+			cw.Write([]string{
+				e.StartedAt.Format(time.RFC3339),
+				e.EndedAt.Format(time.RFC3339),
+				e.Outcome,
+				e.VideoID,
+				e.Title,
+				tt.Clean,
+				strings.Join(tt.Featured, ", "),
+				strconv.Itoa(e.DurationSec),
+				e.AddedBy,
+				strconv.FormatBool(e.IsPaid),
+				strconv.Itoa(e.Amount),
+				e.Source,
+			})
+		}
+		cw.Flush()
+	default:
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "invalid format, use json or csv"})
+	}
+}
+
+// parseHistoryFilter reads from/to (YYYY-MM-DD in local time, or RFC 3339)
+// and user. A date-only "to" includes the whole day.
+func parseHistoryFilter(r *http.Request) (HistoryFilter, error) {
+	q := r.URL.Query()
+	f := HistoryFilter{User: q.Get("user")}
+	var err error
+	if f.From, err = parseDateParam(q.Get("from"), false); err != nil {
+		return f, fmt.Errorf("invalid from parameter")
+	}
+	if f.To, err = parseDateParam(q.Get("to"), true); err != nil {
+		return f, fmt.Errorf("invalid to parameter")
+	}
+	return f, nil
+}
+
+func parseDateParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	sum, err := s.sessions.summary(r.URL.Query().Get("id"))
+	if err != nil {
+		reply(w, http.StatusNotFound, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: sum})
+}
+
+// handleStats reports leaderboards and rates for range=day (since local
+// midnight), range=session (the active or given/last session) or all time.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var from, to time.Time
+	rng := q.Get("range")
+	switch rng {
+	case "", "all":
+		rng = "all"
+	case "day":
+		now := time.Now()
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	case "session":
+		sum, err := s.sessions.summary(q.Get("id"))
+		if err != nil {
+			reply(w, http.StatusNotFound, apiResponse{Success: false, Message: err.Error()})
+			return
+		}
+		from, to = sum.StartedAt, sum.EndedAt
+	default:
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "invalid range, use day, session or all"})
+		return
+	}
+	top, _ := strconv.Atoi(q.Get("top"))
+	if top < 1 {
+		top = 10
+	}
+	st := computeStats(s.cache, from, to, top)
+	st.Range = rng
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: st})
+}
+
+func (s *Server) handleSessionStart(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	sess, started := s.p.startSession()
+	if !started {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "session already active", Data: sess})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Session started", Data: sess})
+}
+
+func (s *Server) handleSessionEnd(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	sum, err := s.p.endSession()
+	if err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Session ended", Data: sum})
+}
+
 func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
 		reply(w, http.StatusMethodNotAllowed, apiResponse{Success: false, Message: "Method not allowed"})
@@ -256,6 +505,42 @@ func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Track removed from queue", Data: t})
 }
 
+func (s *Server) handleQueueMove(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil || from < 0 {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Invalid from parameter"})
+		return
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil || to < 0 {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Invalid to parameter"})
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+	t, err := s.p.move(from, to, force)
+	if err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Track moved", Data: t})
+}
+
+func (s *Server) handleQueueOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		reply(w, http.StatusOK, apiResponse{Success: true, Data: map[string]any{"mode": s.p.getOrderMode()}})
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if err := s.p.setOrderMode(mode); err != nil {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Queue order updated", Data: map[string]any{"mode": mode}})
+}
+
 func (s *Server) handleRemovePlayed(w http.ResponseWriter, r *http.Request) {
 	if !requirePost(w, r) {
 		return
@@ -286,10 +571,12 @@ func (s *Server) handlePlaylistSet(w http.ResponseWriter, r *http.Request) {
 		reply(w, http.StatusInternalServerError, apiResponse{Success: false, Message: "Playlist manager not initialized"})
 		return
 	}
-	if err := pl.load(pu); err != nil {
+	if err := pl.load(r.Context(), pu); err != nil {
 		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
 		return
 	}
+	pl.setManual()
+	s.p.broadcastPlaylistUpdate()
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Playlist loaded successfully", Data: pl.status()})
 }
 
@@ -348,7 +635,7 @@ func (s *Server) handlePlaylistReload(w http.ResponseWriter, r *http.Request) {
 		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "No playlist loaded"})
 		return
 	}
-	if err := pl.reload("https://www.youtube.com/playlist?list=" + pid); err != nil {
+	if err := pl.reload(r.Context(), "https://www.youtube.com/playlist?list=" + pid); err != nil {
 		reply(w, http.StatusInternalServerError, apiResponse{Success: false, Message: "Failed to reload: " + err.Error()})
 		return
 	}
@@ -399,6 +686,48 @@ func (s *Server) handlePlaylistShuffle(w http.ResponseWriter, r *http.Request) {
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Playlist shuffle toggled", Data: pl.status()})
 }
 
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	st := s.cache.stats()
+	if yc, ok := s.yt.(*YouTubeClient); ok {
+		st.StalePending = yc.stalePending()
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: st})
+}
+
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	if s.hub.quota == nil {
+		reply(w, http.StatusOK, apiResponse{Success: true, Data: map[string]any{"enabled": false}})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: s.hub.quota.status()})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery(r.URL.Query().Get("q"))
+	if q == "" {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Missing query"})
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > maxSearchLimit {
+		limit = defaultSearchLimit
+	}
+	results, err := s.yt.search(r.Context(), q, limit, false)
+	if errors.Is(err, errQuotaReserve) {
+		reply(w, http.StatusTooManyRequests, apiResponse{Success: false, Message: err.Error(), Data: map[string]any{"reason": "quota_reserve"}})
+		return
+	}
+	if errors.Is(err, errYouTubeUnavailable) {
+		reply(w, http.StatusServiceUnavailable, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		reply(w, http.StatusInternalServerError, apiResponse{Success: false, Message: err.Error()})
+		return
+	}
+	reply(w, http.StatusOK, apiResponse{Success: true, Data: map[string]any{"query": q, "results": results}})
+}
+
 func (s *Server) handleDonationStatus(w http.ResponseWriter, r *http.Request) {
 	reply(w, http.StatusOK, apiResponse{Success: true, Data: map[string]any{"enabled": s.donationOn}})
 }
@@ -432,16 +761,28 @@ func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
 
 	st := s.p.currentState()
 	st.OverlayMode = s.hub.getOverlayMode()
+	if s.hub.quota != nil {
+		q := s.hub.quota.status()
+		st.Quota = &q
+	}
 	conn.WriteJSON(st)
 
 	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
 			s.hub.mu.Lock()
 			delete(s.hub.conns, conn)
 			s.hub.mu.Unlock()
 			conn.Close()
 			return
 		}
+		var msg wsMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		if msg.Type == "progress" {
+			s.p.reportProgress(msg.VideoID, msg.Elapsed)
+		}
 	}
 }
 
@@ -476,11 +817,16 @@ func (s *Server) handleModerationApprove(w http.ResponseWriter, r *http.Request)
 		reply(w, http.StatusNotFound, apiResponse{Success: false, Message: "Donation not found"})
 		return
 	}
-	if err := s.p.approveTrack(item.VideoID, item.DisplayName); err != nil {
+	if item.VideoID == "" {
+		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: "Donation has no video to approve; add the track manually and reject it"})
+		return
+	}
+	if err := s.p.approveTrack(r.Context(), VideoRef{ID: item.VideoID, StartSec: item.StartSec, EndSec: item.EndSec}, item.DisplayName, item.Amount); err != nil {
 		reply(w, http.StatusBadRequest, apiResponse{Success: false, Message: err.Error()})
 		return
 	}
 	s.moderation.remove(id)
+	s.sessions.noteModeration(true)
 	s.hub.send(s.p.currentState())
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Track approved and added to queue"})
 }
@@ -498,6 +844,7 @@ func (s *Server) handleModerationReject(w http.ResponseWriter, r *http.Request)
 		reply(w, http.StatusNotFound, apiResponse{Success: false, Message: "Donation not found"})
 		return
 	}
+	s.sessions.noteModeration(false)
 	reply(w, http.StatusOK, apiResponse{Success: true, Message: "Donation rejected"})
 }
 
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// Breaker stops calls to a failing upstream. After threshold consecutive
+// failures it opens and refuses calls for cooldown; then it lets a single
+// probe through (half-open), which either closes it again or reopens it.
+type Breaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastErr   string
+}
+
+type BreakerStatus struct {
+	State     string    `json:"state"`
+	Failures  int       `json:"consecutive_failures"`
+	RetryAt   time.Time `json:"retry_at,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// allow reports whether a call may go ahead. An open breaker refuses
+// calls until its cooldown is over and then admits one probe at a time.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("circuit breaker open until %s after %d failures",
+				b.openedAt.Add(b.cooldown).Format("15:04:05"), b.failures)
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("circuit breaker half-open, probe in flight")
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *Breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		log.Printf("%s circuit breaker closed", b.name)
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *Breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err.Error()
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		log.Printf("%s circuit breaker open for %s after %d failures: %v", b.name, b.cooldown, b.failures, err)
+	}
+}
+
+// abort ends a call that says nothing about the upstream's health (it
+// was cancelled, or refused before reaching it) without counting it.
+func (b *Breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *Breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := BreakerStatus{State: b.state, Failures: b.failures, LastError: b.lastErr}
+	if b.state == breakerOpen {
+		st.RetryAt = b.openedAt.Add(b.cooldown)
+	}
+	return st
+}
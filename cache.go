@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"log"
 	"sort"
 	"time"
@@ -13,14 +14,32 @@ import (
 const (
 	maxVideos       = 50000
 	maxPlaylists    = 500
+	maxMissing      = 5000
 	videoTTL        = 7 * 24 * time.Hour
 	videoTTLBlocked = 24 * time.Hour
 	playlistTTL     = 7 * 24 * time.Hour
+	// videoTTLLive is kept short: a premiere or stream turns into a
+	// normal video as soon as it ends.
+	videoTTLLive = 10 * time.Minute
+	// missingTTL is kept short: a private video may be made public.
+	missingTTL = time.Hour
+
+	// maxMissingListed caps the entries listed in /api/cache.
+	maxMissingListed = 100
 )
 
 var (
-	bucketPlaylist = []byte("playlist")
-	bucketVideos   = []byte("videos")
+	bucketPlaylist  = []byte("playlist")
+	bucketVideos    = []byte("videos")
+	bucketState     = []byte("state")
+	bucketHistory   = []byte("history")
+	bucketSessions  = []byte("sessions")
+	bucketRequests  = []byte("requests")
+	bucketDonations = []byte("donations")
+	bucketQuota     = []byte("quota")
+	bucketMissing   = []byte("missing")
+
+	stateKeyPlayer = []byte("player")
 )
 
 type VideoEntry struct {
@@ -29,10 +48,73 @@ type VideoEntry struct {
 	Views      int
 	Embeddable bool
 	CategoryId string
+	Broadcast  string
 	CachedAt   time.Time
 	TTL        time.Duration
+	Restrictions
+	VideoMeta
 }
 
+func (e VideoEntry) info() VideoInfo {
+	return VideoInfo{
+		Title:        e.Title,
+		Duration:     e.Duration,
+		Views:        e.Views,
+		Embeddable:   e.Embeddable,
+		CategoryId:   e.CategoryId,
+		Broadcast:    e.Broadcast,
+		Restrictions: e.Restrictions,
+		VideoMeta:    e.VideoMeta,
+	}
+}
+
+// Kinds of MissingEntry.
+const (
+	missingNotFound    = "not_found"
+	missingBadDuration = "bad_duration"
+)
+
+// MissingEntry remembers a lookup that failed because of the video itself
+// (it is gone, or its metadata is unusable), so repeated links do not
+// spend quota again.
+type MissingEntry struct {
+	Kind     string
+	Message  string
+	CachedAt time.Time
+}
+
+// missingEntryFor returns the entry to cache for err, if err is one of
+// the failures worth remembering.
+func missingEntryFor(err error) (MissingEntry, bool) {
+	var kind string
+	switch {
+	case errors.Is(err, errVideoNotFound):
+		kind = missingNotFound
+	case errors.Is(err, errBadDuration):
+		kind = missingBadDuration
+	default:
+		return MissingEntry{}, false
+	}
+	return MissingEntry{Kind: kind, Message: err.Error()}, true
+}
+
+// err replays the original failure: same message, same sentinel.
+func (e MissingEntry) err() error {
+	kind := errVideoNotFound
+	if e.Kind == missingBadDuration {
+		kind = errBadDuration
+	}
+	return cachedError{msg: e.Message, kind: kind}
+}
+
+type cachedError struct {
+	msg  string
+	kind error
+}
+
+func (e cachedError) Error() string { return e.msg }
+func (e cachedError) Unwrap() error { return e.kind }
+
 type PlaylistEntry struct {
 	Tracks   []PlaylistTrack
 	CachedAt time.Time
@@ -45,6 +127,33 @@ type PlaylistTrack struct {
 	Views       int
 	Embeddable  bool
 	CategoryId  string
+	Restrictions
+	VideoMeta
+}
+
+// PlayerSnapshot is the persisted live queue and playback state, restored
+// on startup so queued (and paid) requests survive a restart.
+type PlayerSnapshot struct {
+	Items     []*Track
+	Cursor    int
+	State     string
+	OrderMode string
+	// OrderModeConfig is the queue_order value OrderMode was set against.
+	OrderModeConfig string
+	Elapsed         float64
+	Playlist        PlaylistSnapshot
+	SavedAt         time.Time
+}
+
+type PlaylistSnapshot struct {
+	PlaylistID   string
+	CurrentIndex int
+	Order        []int
+	Shuffled     bool
+	Enabled      bool
+	// Manual is set when the playlist came from /api/playlist/set rather
+	// than fallback_playlist_url.
+	Manual bool
 }
 
 type Cache struct {
@@ -57,11 +166,12 @@ func openCache(path string, _ time.Duration) (*Cache, error) {
 		return nil, err
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists(bucketPlaylist); err != nil {
-			return err
+		for _, name := range [][]byte{bucketPlaylist, bucketVideos, bucketState, bucketHistory, bucketSessions, bucketRequests, bucketDonations, bucketQuota, bucketMissing} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
 		}
-		_, err = tx.CreateBucketIfNotExists(bucketVideos)
-		return err
+		return nil
 	})
 	if err != nil {
 		db.Close()
@@ -85,6 +195,24 @@ func gobDecode(data []byte, v any) error {
 }
 
 func (c *Cache) getVideo(id string) (VideoEntry, bool) {
+	e, ok := c.loadVideo(id)
+	if !ok || e.expiredFor() > 0 {
+		return VideoEntry{}, false
+	}
+	return e, true
+}
+
+// getStaleVideo returns an entry whose TTL ran out no more than maxStale
+// ago, for use when the API cannot be reached.
+func (c *Cache) getStaleVideo(id string, maxStale time.Duration) (VideoEntry, bool) {
+	e, ok := c.loadVideo(id)
+	if !ok || e.expiredFor() > maxStale {
+		return VideoEntry{}, false
+	}
+	return e, true
+}
+
+func (c *Cache) loadVideo(id string) (VideoEntry, bool) {
 	var e VideoEntry
 	_ = c.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketVideos).Get([]byte(id))
@@ -96,14 +224,16 @@ func (c *Cache) getVideo(id string) (VideoEntry, bool) {
 	if e.Title == "" {
 		return VideoEntry{}, false
 	}
+	return e, true
+}
+
+// expiredFor is how long ago e's TTL ran out; zero or less while fresh.
+func (e VideoEntry) expiredFor() time.Duration {
 	ttl := e.TTL
 	if ttl == 0 {
 		ttl = videoTTL
 	}
-	if time.Since(e.CachedAt) > ttl {
-		return VideoEntry{}, false
-	}
-	return e, true
+	return time.Since(e.CachedAt) - ttl
 }
 
 func (c *Cache) setVideo(id string, e VideoEntry) {
@@ -158,12 +288,137 @@ func (c *Cache) setPlaylist(id string, e PlaylistEntry) {
 	})
 }
 
+func (c *Cache) getMissing(id string) (MissingEntry, bool) {
+	var e MissingEntry
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMissing).Get([]byte(id))
+		if b == nil {
+			return nil
+		}
+		return gobDecode(b, &e)
+	})
+	if e.Kind == "" || time.Since(e.CachedAt) > missingTTL {
+		return MissingEntry{}, false
+	}
+	return e, true
+}
+
+// setMissing caches a failed lookup of id when err is worth remembering
+// (see missingEntryFor) and does nothing otherwise.
+func (c *Cache) setMissing(id string, err error) {
+	e, ok := missingEntryFor(err)
+	if !ok {
+		return
+	}
+	e.CachedAt = time.Now()
+	data, encErr := gobEncode(e)
+	if encErr != nil {
+		return
+	}
+	_ = c.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucketMissing)
+		if bkt.Stats().KeyN >= maxMissing {
+			if err := evictOldestFromBucket(bkt, bkt.Stats().KeyN-maxMissing+1); err != nil {
+				log.Printf("Missing video cache eviction error: %v", err)
+			}
+		}
+		return bkt.Put([]byte(id), data)
+	})
+}
+
+// CacheStats describes the metadata cache for /api/cache.
+type CacheStats struct {
+	Videos    int `json:"videos"`
+	Playlists int `json:"playlists"`
+	// Missing counts live negative entries; MissingEntries lists the
+	// most recent of them.
+	Missing        int            `json:"missing"`
+	MissingEntries []MissingVideo `json:"missing_entries"`
+	// StalePending is the number of videos served stale and waiting to
+	// be refreshed (YouTube API only).
+	StalePending int `json:"stale_pending"`
+}
+
+type MissingVideo struct {
+	VideoID   string    `json:"video_id"`
+	Kind      string    `json:"kind"`
+	Reason    string    `json:"reason"`
+	CachedAt  time.Time `json:"cached_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c *Cache) stats() CacheStats {
+	st := CacheStats{MissingEntries: []MissingVideo{}}
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		st.Videos = tx.Bucket(bucketVideos).Stats().KeyN
+		st.Playlists = tx.Bucket(bucketPlaylist).Stats().KeyN
+		return tx.Bucket(bucketMissing).ForEach(func(k, v []byte) error {
+			var e MissingEntry
+			if gobDecode(v, &e) != nil || time.Since(e.CachedAt) > missingTTL {
+				return nil
+			}
+			st.MissingEntries = append(st.MissingEntries, MissingVideo{
+				VideoID:   string(k),
+				Kind:      e.Kind,
+				Reason:    e.Message,
+				CachedAt:  e.CachedAt,
+				ExpiresAt: e.CachedAt.Add(missingTTL),
+			})
+			return nil
+		})
+	})
+	st.Missing = len(st.MissingEntries)
+	sort.Slice(st.MissingEntries, func(i, j int) bool {
+		return st.MissingEntries[i].CachedAt.After(st.MissingEntries[j].CachedAt)
+	})
+	if len(st.MissingEntries) > maxMissingListed {
+		st.MissingEntries = st.MissingEntries[:maxMissingListed]
+	}
+	return st
+}
+
+func (c *Cache) deleteVideo(id string) {
+	_ = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketVideos).Delete([]byte(id))
+	})
+}
+
 func (c *Cache) deletePlaylist(id string) {
 	_ = c.db.Update(func(tx *bolt.Tx) error {
 		return tx.Bucket(bucketPlaylist).Delete([]byte(id))
 	})
 }
 
+func (c *Cache) getState() (PlayerSnapshot, bool) {
+	var s PlayerSnapshot
+	found := false
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketState).Get(stateKeyPlayer)
+		if b == nil {
+			return nil
+		}
+		if err := gobDecode(b, &s); err != nil {
+			log.Printf("State snapshot decode error: %v", err)
+			return nil
+		}
+		found = true
+		return nil
+	})
+	return s, found
+}
+
+func (c *Cache) setState(s PlayerSnapshot) {
+	s.SavedAt = time.Now()
+	data, err := gobEncode(s)
+	if err != nil {
+		log.Printf("State snapshot encode error: %v", err)
+		return
+	}
+	_ = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketState).Put(stateKeyPlayer, data)
+	})
+}
+
 func evictOldestFromBucket(bkt *bolt.Bucket, n int) error {
 	type kv struct {
 		key      []byte
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const categoryMusic = "10"
+
+// Track sources that have their own category policy.
+const (
+	policyRequests  = "requests"
+	policyDonations = "donations"
+	policyPlaylist  = "playlist"
+)
+
+var errCategoryNotAllowed = errors.New("video category not allowed")
+
+// categoryNames covers the YouTube categories streamers are likely to run
+// into, for readable rejection messages.
+var categoryNames = map[string]string{
+	"1":  "Film & Animation",
+	"2":  "Autos & Vehicles",
+	"10": "Music",
+	"15": "Pets & Animals",
+	"17": "Sports",
+	"19": "Travel & Events",
+	"20": "Gaming",
+	"22": "People & Blogs",
+	"23": "Comedy",
+	"24": "Entertainment",
+	"25": "News & Politics",
+	"26": "Howto & Style",
+	"27": "Education",
+	"28": "Science & Technology",
+	"29": "Nonprofits & Activism",
+}
+
+// CategoryPolicy decides which video categories (YouTube category IDs) a
+// source accepts. A non-empty Allow admits only those categories; Deny is
+// checked after it. Both empty means every category is accepted.
+type CategoryPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// CategoryPolicies holds one policy per source. A missing policy keeps
+// the music-only default.
+type CategoryPolicies struct {
+	Requests  *CategoryPolicy `json:"requests"`
+	Donations *CategoryPolicy `json:"donations"`
+	Playlist  *CategoryPolicy `json:"playlist"`
+}
+
+var defaultCategoryPolicy = CategoryPolicy{Allow: []string{categoryMusic}}
+
+func (c Config) categoryPolicy(source string) CategoryPolicy {
+	var p *CategoryPolicy
+	switch source {
+	case policyRequests:
+		p = c.Categories.Requests
+	case policyDonations:
+		p = c.Categories.Donations
+	case policyPlaylist:
+		p = c.Categories.Playlist
+	}
+	if p == nil {
+		return defaultCategoryPolicy
+	}
+	return *p
+}
+
+func (p CategoryPolicy) check(categoryId string) error {
+	if (len(p.Allow) > 0 && !slices.Contains(p.Allow, categoryId)) || slices.Contains(p.Deny, categoryId) {
+		return fmt.Errorf("%w: %s", errCategoryNotAllowed, categoryLabel(categoryId))
+	}
+	return nil
+}
+
+func categoryLabel(id string) string {
+	if name, ok := categoryNames[id]; ok {
+		return fmt.Sprintf("%s (%s)", name, id)
+	}
+	if id == "" {
+		return "unknown"
+	}
+	return id
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -17,10 +19,112 @@ type Config struct {
 	RepeatLimit         int    `json:"repeat_limit"`
 	CleanupAfterHours   int    `json:"cleanup_after_hours"`
 	MaxQueueSize        int    `json:"max_queue_size"`
+	QueueOrder          string `json:"queue_order"`
+	PaidOrder           string `json:"paid_order"`
+	UserMaxQueuedFree   int    `json:"user_max_queued_free"`
+	UserMaxQueuedPaid   int    `json:"user_max_queued_paid"`
+	UserMaxRequestsFree int    `json:"user_max_requests_free"`
+	UserMaxRequestsPaid int    `json:"user_max_requests_paid"`
 	DonationWidgetURL   string `json:"donation_widget_url"`
 	DonationMinAmount   int    `json:"donation_min_amount"`
-	YouTubeAPIKey       string `json:"youtube_api_key"`
+	// DonationSearch resolves donation messages without a link to the top
+	// search result. Matches below DonationSearchMinConfidence (0..1) go
+	// to moderation instead of the queue.
+	DonationSearch              bool    `json:"donation_search"`
+	DonationSearchMinConfidence float64 `json:"donation_search_min_confidence"`
+	YouTubeAPIKey               string  `json:"youtube_api_key"`
+	// YouTubeAPIKeys are extra keys rotated through when one runs out of
+	// quota or is rejected. YouTubeAPIKey, if set, is tried first.
+	YouTubeAPIKeys    []string `json:"youtube_api_keys"`
+	YouTubeAPIBaseURL string   `json:"youtube_api_base_url"`
+	// YouTubeDailyQuota is the quota of a single key.
+	YouTubeDailyQuota int `json:"youtube_daily_quota"`
+	// YouTubeQuotaReserve is kept for viewer requests: non-essential calls
+	// such as playlist loads stop once only this much quota is left.
+	YouTubeQuotaReserve int `json:"youtube_quota_reserve"`
+	// MetadataFixtures, when set, replaces the YouTube API with a
+	// fixture-backed fake provider (offline testing).
+	MetadataFixtures    string `json:"metadata_fixtures"`
 	FallbackPlaylistURL string `json:"fallback_playlist_url"`
+	// Categories restricts which YouTube categories requests, donations
+	// and playlist tracks may come from. Music only by default.
+	Categories CategoryPolicies `json:"categories"`
+	// StreamerRegion is the ISO 3166-1 alpha-2 country the stream plays
+	// from; videos blocked there are rejected. Empty skips the check.
+	StreamerRegion string `json:"streamer_region"`
+	// AgeRestricted is the policy for age-restricted videos: "reject"
+	// (default) or "allow".
+	AgeRestricted string `json:"age_restricted"`
+	// CacheMaxStaleHours is how long past its TTL cached video metadata
+	// may still be used when the API is down or out of quota. Negative
+	// disables stale fallback.
+	CacheMaxStaleHours int `json:"cache_max_stale_hours"`
+	// WatchdogGraceSec is how long past a track's duration the watchdog
+	// waits before advancing on its own. Negative disables it.
+	WatchdogGraceSec int `json:"watchdog_grace_seconds"`
+	// TitleStripPatterns are extra regular expressions (case-insensitive)
+	// removed from titles in now-playing output, on top of the defaults.
+	TitleStripPatterns []string `json:"title_strip_patterns"`
+
+	titles *TitleNormalizer
+}
+
+func (c *Config) applyDefaults() {
+	if c.MaxQueueSize == 0 {
+		c.MaxQueueSize = 100
+	}
+	if c.YouTubeDailyQuota == 0 {
+		c.YouTubeDailyQuota = defaultDailyQuota
+	}
+	if c.DonationSearchMinConfidence == 0 {
+		c.DonationSearchMinConfidence = 0.75
+	}
+	if c.CacheMaxStaleHours == 0 {
+		c.CacheMaxStaleHours = 7 * 24
+	}
+	if c.WatchdogGraceSec == 0 {
+		c.WatchdogGraceSec = 30
+	}
+}
+
+// compile prepares the parts of the config that can fail to parse.
+func (c *Config) compile() error {
+	n, err := newTitleNormalizer(c.TitleStripPatterns)
+	if err != nil {
+		return err
+	}
+	c.titles = n
+	return nil
+}
+
+func (c Config) titleNormalizer() *TitleNormalizer {
+	if c.titles == nil {
+		return defaultTitleNormalizer
+	}
+	return c.titles
+}
+
+func (c Config) cacheMaxStale() time.Duration {
+	if c.CacheMaxStaleHours < 0 {
+		return 0
+	}
+	return time.Duration(c.CacheMaxStaleHours) * time.Hour
+}
+
+// apiKeys returns the configured YouTube API keys, deduplicated, with the
+// single youtube_api_key first.
+func (c Config) apiKeys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, k := range append([]string{c.YouTubeAPIKey}, c.YouTubeAPIKeys...) {
+		k = strings.TrimSpace(k)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		keys = append(keys, k)
+	}
+	return keys
 }
 
 type ConfigManager struct {
@@ -37,8 +141,9 @@ func loadConfig() (*ConfigManager, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
-	if cfg.MaxQueueSize == 0 {
-		cfg.MaxQueueSize = 100
+	cfg.applyDefaults()
+	if err := cfg.compile(); err != nil {
+		return nil, err
 	}
 	return &ConfigManager{cfg: cfg}, nil
 }
@@ -79,8 +184,10 @@ func (m *ConfigManager) reload() {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return
 	}
-	if cfg.MaxQueueSize == 0 {
-		cfg.MaxQueueSize = 100
+	cfg.applyDefaults()
+	if err := cfg.compile(); err != nil {
+		log.Printf("Config not reloaded: %v", err)
+		return
 	}
 	m.mu.Lock()
 	m.cfg = cfg
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,6 +17,11 @@ import (
 
 const maxSeenDonations = 500
 
+// donationRetryDelays are the waits between background retries of a
+// donation whose lookup failed because YouTube was unavailable, about 20
+// minutes in all.
+var donationRetryDelays = []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute}
+
 // isModerationError returns true for config-based rejections a streamer
 // can override, false for hard technical failures.
 func isModerationError(err error) bool {
@@ -24,7 +31,11 @@ func isModerationError(err error) bool {
 		"insufficient views",
 		"queue is full",
 		"repeat limit",
-		"only music videos are allowed",
+		"user queue limit",
+		"user request limit",
+		"video category not allowed",
+		"blocked in the streamer's region",
+		"age-restricted",
 	} {
 		if strings.Contains(msg, s) {
 			return true
@@ -42,9 +53,11 @@ type DonationMonitor struct {
 	seenDonations map[string]time.Time
 	mu            sync.Mutex
 	backoff       time.Duration
-	addTrack      func(vid, by string, paid bool) error
+	addTrack      func(ctx context.Context, ref VideoRef, by string, paid bool, amount int) error
 	moderation    *ModerationQueue
-	yt            *YouTubeClient
+	yt            MetadataProvider
+	cfg           *ConfigManager
+	onDonation    func(name string, amount int)
 }
 
 type donationAuthResponse struct {
@@ -68,7 +81,7 @@ type donationData struct {
 	Message     string `json:"message"`
 }
 
-func newDonationMonitor(widgetURL string, minAmount int, addTrack func(vid, by string, paid bool) error, mod *ModerationQueue, yt *YouTubeClient) (*DonationMonitor, error) {
+func newDonationMonitor(widgetURL string, minAmount int, addTrack func(ctx context.Context, ref VideoRef, by string, paid bool, amount int) error, mod *ModerationQueue, yt MetadataProvider, cfg *ConfigManager, onDonation func(name string, amount int)) (*DonationMonitor, error) {
 	m := &DonationMonitor{
 		widgetURL:     widgetURL,
 		minAmount:     minAmount,
@@ -77,6 +90,8 @@ func newDonationMonitor(widgetURL string, minAmount int, addTrack func(vid, by s
 		addTrack:      addTrack,
 		moderation:    mod,
 		yt:            yt,
+		cfg:           cfg,
+		onDonation:    onDonation,
 	}
 	u, err := url.Parse(widgetURL)
 	if err != nil {
@@ -168,10 +183,6 @@ func (m *DonationMonitor) processEvent(data string) {
 		return
 	}
 	log.Printf("Donation received: %s donated %d - %s", dd.DisplayName, dd.Amount, dd.Message)
-	if dd.Amount < m.minAmount {
-		log.Printf("Skipping donation (%d < %d min)", dd.Amount, m.minAmount)
-		return
-	}
 	m.mu.Lock()
 	if _, seen := m.seenDonations[dd.RefID]; seen {
 		m.mu.Unlock()
@@ -183,36 +194,120 @@ func (m *DonationMonitor) processEvent(data string) {
 		m.evictOldest()
 	}
 	m.mu.Unlock()
-
-	vid := extractVideoID(dd.Message)
-	if vid == "" {
-		log.Printf("No YouTube link in donation from %s", dd.DisplayName)
+	if m.onDonation != nil {
+		m.onDonation(dd.DisplayName, dd.Amount)
+	}
+	if dd.Amount < m.minAmount {
+		log.Printf("Skipping donation (%d < %d min)", dd.Amount, m.minAmount)
 		return
 	}
 
-	log.Printf("Adding donation track from %s: %s", dd.DisplayName, vid)
-	go func() {
-		if err := m.addTrack(vid, dd.DisplayName, true); err != nil {
-			if !isModerationError(err) {
-				log.Printf("Donation track rejected (technical): %v", err)
-				return
-			}
-			log.Printf("Donation track pending moderation from %s: %v", dd.DisplayName, err)
-			title := vid
-			if info, infoErr := m.yt.getVideoInfoForce(vid); infoErr == nil {
-				title = info.Title
-			}
-			m.moderation.add(&PendingDonation{
-				ID:          dd.RefID,
-				DisplayName: dd.DisplayName,
-				Amount:      dd.Amount,
-				VideoID:     vid,
-				VideoTitle:  title,
-				Reason:      err.Error(),
-				ReceivedAt:  time.Now(),
-			})
+	ref := extractVideoRef(dd.Message)
+	if ref.ID == "" {
+		if !m.cfg.get().DonationSearch {
+			log.Printf("No YouTube link in donation from %s", dd.DisplayName)
+			return
 		}
-	}()
+		go m.retrying(dd, func() error { return m.resolveBySearch(dd) }, func(err error) {
+			// Nothing to approve yet; the streamer sees the request and
+			// can add the track by hand.
+			m.hold(dd, VideoRef{}, searchQuery(dd.Message), fmt.Sprintf("search failed: %v", err))
+		})
+		return
+	}
+
+	log.Printf("Adding donation track from %s: %s", dd.DisplayName, ref.ID)
+	go m.retrying(dd, func() error { return m.submit(dd, ref) }, func(err error) {
+		m.hold(dd, ref, ref.ID, fmt.Sprintf("lookup failed: %v", err))
+	})
+}
+
+// retrying runs a donation lookup and, while YouTube is unavailable,
+// retries it in the background. If it still fails after the last retry,
+// giveUp takes over so that the donation is not lost.
+func (m *DonationMonitor) retrying(dd donationData, lookup func() error, giveUp func(err error)) {
+	err := lookup()
+	for i := 0; err != nil && i < len(donationRetryDelays); i++ {
+		log.Printf("Donation from %s: %v, retrying in %s", dd.DisplayName, err, donationRetryDelays[i])
+		time.Sleep(donationRetryDelays[i])
+		err = lookup()
+	}
+	if err == nil {
+		return
+	}
+	log.Printf("Donation from %s: giving up after %d retries: %v", dd.DisplayName, len(donationRetryDelays), err)
+	giveUp(err)
+}
+
+// submit adds a donation track, holding it for moderation when a rule
+// the streamer can override rejects it. Only errors worth retrying
+// (errYouTubeUnavailable) are returned; other failures are logged.
+func (m *DonationMonitor) submit(dd donationData, ref VideoRef) error {
+	ctx := context.Background()
+	err := m.addTrack(ctx, ref, dd.DisplayName, true, dd.Amount)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, errYouTubeUnavailable) {
+		return err
+	}
+	if !isModerationError(err) {
+		log.Printf("Donation track rejected (technical): %v", err)
+		return nil
+	}
+	log.Printf("Donation track pending moderation from %s: %v", dd.DisplayName, err)
+	title := ref.ID
+	if info, infoErr := m.yt.getVideoInfo(ctx, ref.ID); infoErr == nil {
+		title = info.Title
+	}
+	m.hold(dd, ref, title, err.Error())
+	return nil
+}
+
+// resolveBySearch treats a link-less donation message as a search query
+// and submits the top result. A weak match goes to moderation instead.
+// Like submit, it returns only errors worth retrying.
+func (m *DonationMonitor) resolveBySearch(dd donationData) error {
+	q := searchQuery(dd.Message)
+	if q == "" {
+		log.Printf("Empty donation message from %s", dd.DisplayName)
+		return nil
+	}
+	results, err := m.yt.search(context.Background(), q, 1, true)
+	if errors.Is(err, errYouTubeUnavailable) {
+		return err
+	}
+	if err != nil {
+		log.Printf("Donation search failed for %s: %v", dd.DisplayName, err)
+		return nil
+	}
+	if len(results) == 0 {
+		log.Printf("No search results for donation from %s: %q", dd.DisplayName, q)
+		return nil
+	}
+	top := results[0]
+	ref := VideoRef{ID: top.VideoID}
+	if minConf := m.cfg.get().DonationSearchMinConfidence; top.Confidence < minConf {
+		log.Printf("Donation search match held for moderation from %s: %q -> %s (%.0f%%)", dd.DisplayName, q, top.Title, top.Confidence*100)
+		m.hold(dd, ref, top.Title, fmt.Sprintf("low search match confidence (%.0f%%) for %q", top.Confidence*100, q))
+		return nil
+	}
+	log.Printf("Adding donation track from %s by search: %q -> %s", dd.DisplayName, q, top.Title)
+	return m.submit(dd, ref)
+}
+
+func (m *DonationMonitor) hold(dd donationData, ref VideoRef, title, reason string) {
+	m.moderation.add(&PendingDonation{
+		ID:          dd.RefID,
+		DisplayName: dd.DisplayName,
+		Amount:      dd.Amount,
+		VideoID:     ref.ID,
+		StartSec:    ref.StartSec,
+		EndSec:      ref.EndSec,
+		VideoTitle:  title,
+		Reason:      reason,
+		ReceivedAt:  time.Now(),
+	})
 }
 
 func (m *DonationMonitor) evictOldest() {
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	outcomeCompleted = "completed"
+	outcomeSkipped   = "skipped"
+	outcomeError     = "error"
+
+	sourceRequest  = "request"
+	sourcePlaylist = "playlist"
+)
+
+// HistoryEntry is one played track, written when the track stops being
+// the current one.
+type HistoryEntry struct {
+	ID          uint64    `json:"id"`
+	VideoID     string    `json:"video_id"`
+	Title       string    `json:"title"`
+	DurationSec int       `json:"duration_sec"`
+	AddedBy     string    `json:"added_by"`
+	IsPaid      bool      `json:"is_paid"`
+	Amount      int       `json:"amount,omitempty"`
+	Source      string    `json:"source"`
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	Outcome     string    `json:"outcome"`
+}
+
+type HistoryFilter struct {
+	From time.Time
+	To   time.Time
+	User string
+}
+
+func (f HistoryFilter) match(e *HistoryEntry) bool {
+	if !f.From.IsZero() && e.StartedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.StartedAt.Before(f.To) {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(e.AddedBy, f.User) {
+		return false
+	}
+	return true
+}
+
+func trackSource(t *Track) string {
+	if t.FromPlaylist {
+		return sourcePlaylist
+	}
+	return sourceRequest
+}
+
+func (c *Cache) addHistory(e HistoryEntry) {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucketHistory)
+		id, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+		data, err := gobEncode(e)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(seqKey(id), data)
+	})
+	if err != nil {
+		log.Printf("History write error: %v", err)
+	}
+}
+
+// queryHistory returns matching entries newest first, skipping offset
+// matches and returning at most limit (limit <= 0 means all), along with
+// the total number of matches.
+func (c *Cache) queryHistory(f HistoryFilter, offset, limit int) ([]HistoryEntry, int) {
+	out := []HistoryEntry{}
+	total := 0
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(bucketHistory).Cursor()
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			var e HistoryEntry
+			if err := gobDecode(v, &e); err != nil || !f.match(&e) {
+				continue
+			}
+			total++
+			if total <= offset || (limit > 0 && len(out) >= limit) {
+				continue
+			}
+			out = append(out, e)
+		}
+		return nil
+	})
+	return out, total
+}
+
+func seqKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	keyStatusOK        = "ok"
+	keyStatusExhausted = "exhausted"
+	keyStatusInvalid   = "invalid"
+)
+
+var errNoUsableKey = errors.New("all YouTube API keys are exhausted or invalid until the quota resets")
+
+// KeyPool rotates between YouTube API keys. A key that runs out of quota
+// or is rejected is skipped until the next quota day.
+type KeyPool struct {
+	mu   sync.Mutex
+	keys []*apiKeyState
+	cur  int
+}
+
+type apiKeyState struct {
+	key     string
+	status  string
+	badDay  string
+	reason  string
+	usedDay string
+	used    int
+}
+
+type KeyHealth struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Active bool   `json:"active"`
+	Used   int    `json:"used_today"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newKeyPool(keys []string) *KeyPool {
+	p := &KeyPool{}
+	for _, k := range keys {
+		p.keys = append(p.keys, &apiKeyState{key: k, status: keyStatusOK})
+	}
+	return p
+}
+
+func (p *KeyPool) size() int { return len(p.keys) }
+
+// refreshLocked clears marks and counters left over from an earlier day.
+func (s *apiKeyState) refreshLocked(day string) {
+	if s.status != keyStatusOK && s.badDay != day {
+		s.status, s.reason = keyStatusOK, ""
+	}
+	if s.usedDay != day {
+		s.usedDay, s.used = day, 0
+	}
+}
+
+// pick returns the first usable key starting at the current one.
+func (p *KeyPool) pick() (int, string, error) {
+	if len(p.keys) == 0 {
+		return 0, "", fmt.Errorf("YouTube API key not configured")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	day := quotaDay(time.Now())
+	for i := range p.keys {
+		idx := (p.cur + i) % len(p.keys)
+		s := p.keys[idx]
+		s.refreshLocked(day)
+		if s.status == keyStatusOK {
+			p.cur = idx
+			return idx, s.key, nil
+		}
+	}
+	return 0, "", errNoUsableKey
+}
+
+func (p *KeyPool) spend(idx, units int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.keys[idx]
+	s.refreshLocked(quotaDay(time.Now()))
+	s.used += units
+}
+
+// markBad takes a key out of rotation until the quota resets and moves
+// on to the next one.
+func (p *KeyPool) markBad(idx int, status, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.keys[idx]
+	s.status, s.reason, s.badDay = status, reason, quotaDay(time.Now())
+	if p.cur == idx {
+		p.cur = (idx + 1) % len(p.keys)
+	}
+}
+
+func (p *KeyPool) health() []KeyHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	day := quotaDay(time.Now())
+	out := make([]KeyHealth, 0, len(p.keys))
+	for i, s := range p.keys {
+		s.refreshLocked(day)
+		out = append(out, KeyHealth{
+			Key:    maskKey(s.key),
+			Status: s.status,
+			Active: i == p.cur && s.status == keyStatusOK,
+			Used:   s.used,
+			Reason: s.reason,
+		})
+	}
+	return out
+}
+
+func maskKey(k string) string {
+	if len(k) <= 8 {
+		return "****"
+	}
+	return k[:4] + "…" + k[len(k)-4:]
+}
+
+// apiErrorReason extracts the first error reason from a YouTube API
+// error body, e.g. "quotaExceeded" or "keyInvalid".
+func apiErrorReason(body []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	for _, d := range e.Error.Details {
+		if d.Reason == "API_KEY_INVALID" {
+			return "keyInvalid"
+		}
+	}
+	if len(e.Error.Errors) > 0 {
+		return e.Error.Errors[0].Reason
+	}
+	return ""
+}
+
+// keyStatusForReason maps an API error reason to the key status it
+// implies, or "" when the error is not the key's fault.
+func keyStatusForReason(reason string) string {
+	switch reason {
+	case "quotaExceeded", "dailyLimitExceeded":
+		return keyStatusExhausted
+	case "keyInvalid", "keyExpired", "accessNotConfigured", "ipRefererBlocked", "forbidden":
+		return keyStatusInvalid
+	}
+	return ""
+}
+
+// isRateLimitReason reports whether an API error reason is a short-term
+// rate limit, which clears on its own like a 429.
+func isRateLimitReason(reason string) bool {
+	switch reason {
+	case "rateLimitExceeded", "userRateLimitExceeded":
+		return true
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -26,31 +27,65 @@ func main() {
 	}
 	defer db.close()
 
-	yt := newYouTubeClient(c.YouTubeAPIKey, db)
-	p := newPlayer(cfg, yt)
+	quota := newQuotaTracker(db, cfg)
+	var yt MetadataProvider
+	if c.MetadataFixtures != "" {
+		fake, err := newFakeProvider(c.MetadataFixtures)
+		if err != nil {
+			log.Fatal("Failed to load metadata fixtures:", err)
+		}
+		yt = fake
+		log.Printf("Using fake metadata provider: %s", c.MetadataFixtures)
+	} else {
+		ytc := newYouTubeClient(cfg, db, quota)
+		go ytc.revalidate()
+		yt = ytc
+	}
+	sessions := newSessionManager(db)
+	p := newPlayer(cfg, yt, db, sessions)
+	snap, restored := p.restore()
 	hub := newHub()
+	hub.setQuota(quota)
 
 	mod := newModerationQueue(func() {
 		hub.send(p.currentState())
 	})
 	hub.setModeration(mod)
 
-	pl := newPlaylist(yt, db)
+	pl := newPlaylist(yt, db, cfg)
 	p.setPlaylist(pl)
-	if c.FallbackPlaylistURL != "" {
+	// The saved playlist and position are reused only if the playlist was
+	// set through the API or is still the configured one; otherwise a
+	// changed fallback_playlist_url takes effect on restart.
+	restorePlaylist := restored && snap.Playlist.PlaylistID != "" &&
+		(snap.Playlist.Manual || snap.Playlist.PlaylistID == extractPlaylistID(c.FallbackPlaylistURL))
+	playlistURL := c.FallbackPlaylistURL
+	if restorePlaylist {
+		playlistURL = "https://www.youtube.com/playlist?list=" + snap.Playlist.PlaylistID
+	}
+	if playlistURL != "" {
 		go func() {
-			if err := pl.load(c.FallbackPlaylistURL); err != nil {
+			if err := pl.load(context.Background(), playlistURL); err != nil {
 				log.Printf("Failed to load fallback playlist: %v", err)
 				return
 			}
-			pl.enable()
+			if restorePlaylist {
+				pl.restorePosition(snap.Playlist)
+			} else {
+				pl.enable()
+			}
+			p.broadcastPlaylistUpdate()
 			log.Println("Fallback playlist ready")
 		}()
 	}
 
 	if c.DonationWidgetURL != "" {
 		go func() {
-			mon, err := newDonationMonitor(c.DonationWidgetURL, c.DonationMinAmount, p.validateAndAdd, mod, yt)
+			onDonation := func(name string, amount int) {
+				sessions.noteDonation(amount)
+				db.addDonationEvent(DonationEvent{At: time.Now(), Name: name, Amount: amount})
+			}
+			mon, err := newDonationMonitor(c.DonationWidgetURL, c.DonationMinAmount, p.validateAndAdd, mod, yt, cfg, onDonation)
 			if err != nil {
 				log.Printf("Failed to init donation monitor: %v", err)
 				return
@@ -60,9 +95,10 @@ func main() {
 	}
 
 	go broadcastLoop(p, hub)
+	go p.watchdog()
 	go cleanupLoop(p, cfg)
 
-	srv := newServer(p, hub, yt, c.DonationWidgetURL != "", mod, staticFiles)
+	srv := newServer(p, hub, yt, db, sessions, c.DonationWidgetURL != "", mod, staticFiles)
 	mux := http.NewServeMux()
 	srv.register(mux)
 
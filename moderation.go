@@ -15,6 +15,8 @@ type PendingDonation struct {
 	DisplayName string    `json:"display_name"`
 	Amount      int       `json:"amount"`
 	VideoID     string    `json:"video_id"`
+	StartSec    int       `json:"start_sec,omitempty"`
+	EndSec      int       `json:"end_sec,omitempty"`
 	VideoTitle  string    `json:"video_title"`
 	Reason      string    `json:"reason"`
 	ReceivedAt  time.Time `json:"received_at"`
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -8,14 +10,25 @@ import (
 	"time"
 )
 
+var (
+	errUserQueueLimit   = errors.New("user queue limit reached")
+	errUserRequestLimit = errors.New("user request limit reached")
+)
+
 type PlayerState struct {
 	Action            string             `json:"action"`
 	Current           *Track             `json:"current,omitempty"`
+	CurrentTitle      *TrackTitle        `json:"current_title,omitempty"`
 	Queue             []*Track           `json:"queue,omitempty"`
 	Position          int                `json:"position"`
+	Elapsed           float64            `json:"elapsed"`
+	SeekSeq           int                `json:"seek_seq"`
+	AdvanceReason     string             `json:"advance_reason,omitempty"`
+	QueueOrder        string             `json:"queue_order"`
 	Playlist          PlaylistStatus     `json:"playlist"`
 	OverlayMode       string             `json:"overlay_mode,omitempty"`
 	PendingModeration []*PendingDonation `json:"pending_moderation,omitempty"`
+	Quota             *QuotaStatus       `json:"quota,omitempty"`
 }
 
 type PlaylistStatus struct {
@@ -28,24 +41,99 @@ type PlaylistStatus struct {
 }
 
 type Player struct {
-	mu      sync.Mutex
-	q       Queue
-	cfg     *ConfigManager
-	yt      *YouTubeClient
-	pl      *Playlist
-	state   string
-	updates chan PlayerState
+	mu       sync.Mutex
+	q        Queue
+	cfg      *ConfigManager
+	yt       MetadataProvider
+	pl       *Playlist
+	store    *Cache
+	sessions *SessionManager
+	plSnap   PlaylistSnapshot
+	state    string
+	updates  chan PlayerState
+	// lastState is the state as of the previous broadcast.
+	lastState string
+	// orderMode overrides Config.QueueOrder when set through the API,
+	// until queue_order in the config is changed (orderModeConfig is the
+	// value it had then).
+	orderMode       string
+	orderModeConfig string
+	// requests counts accepted requests per user this session, split by paid.
+	requests map[userKey]int
+	// Playback position as last reported by an overlay (or set by seek).
+	// Only meaningful while posTrack is the current track.
+	posTrack  *Track
+	elapsed   float64
+	elapsedAt time.Time
+	seekSeq   int
+	// heartbeatAt is the time of the last overlay progress report.
+	heartbeatAt time.Time
+	// Watchdog bookkeeping: how long wdTrack has been in the playing state.
+	wdTrack       *Track
+	wdPlayed      time.Duration
+	advanceReason string
+	// History bookkeeping: the track being recorded, when it became
+	// current, and how it ended (set just before advancing).
+	histTrack  *Track
+	histStart  time.Time
+	endOutcome string
+}
+
+type userKey struct {
+	name string
+	paid bool
+}
+
+func newUserKey(by string, paid bool) userKey {
+	return userKey{name: strings.ToLower(strings.TrimSpace(by)), paid: paid}
 }
 
-func newPlayer(cfg *ConfigManager, yt *YouTubeClient) *Player {
+func newPlayer(cfg *ConfigManager, yt MetadataProvider, store *Cache, sessions *SessionManager) *Player {
 	return &Player{
-		state:   "stopped",
-		cfg:     cfg,
-		yt:      yt,
-		updates: make(chan PlayerState, 50),
+		state:    "stopped",
+		cfg:      cfg,
+		yt:       yt,
+		store:    store,
+		sessions: sessions,
+		updates:  make(chan PlayerState, 50),
+		requests: make(map[userKey]int),
 	}
 }
 
+// restore loads the queue and play state saved by the previous run.
+// Must be called before the player starts serving requests.
+func (p *Player) restore() (PlayerSnapshot, bool) {
+	snap, ok := p.store.getState()
+	if !ok {
+		return PlayerSnapshot{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.q.items = snap.Items
+	for _, t := range p.q.items {
+		// Snapshots saved before FromPlaylist existed only name the
+		// playlist in AddedBy.
+		if !t.IsPaid && t.AddedBy == "Playlist" {
+			t.FromPlaylist = true
+		}
+	}
+	p.q.cursor = min(max(snap.Cursor, 0), len(snap.Items))
+	p.state = snap.State
+	p.plSnap = snap.Playlist
+	p.orderMode = snap.OrderMode
+	p.orderModeConfig = snap.OrderModeConfig
+	if cur := p.q.current(); cur != nil && snap.Elapsed > 0 {
+		p.posTrack = cur
+		p.elapsed = snap.Elapsed
+		p.elapsedAt = time.Now()
+	}
+	if p.state == "" || p.q.current() == nil {
+		p.state = "stopped"
+	}
+	log.Printf("Restored queue: %d tracks, position %d, state %s", len(p.q.items), p.q.cursor, p.state)
+	return snap, true
+}
+
 func (p *Player) setPlaylist(pl *Playlist) {
 	p.mu.Lock()
 	p.pl = pl
@@ -64,25 +152,45 @@ func (p *Player) broadcastPlaylistUpdate() {
 	p.broadcast()
 }
 
-func (p *Player) validateAndAdd(vid, by string, paid bool) error {
-	info, err := p.yt.getVideoInfo(vid)
+func (p *Player) validateAndAdd(ctx context.Context, ref VideoRef, by string, paid bool, amount int) (err error) {
+	vid := ref.ID
+	title := vid
+	defer func() { p.recordRequest(vid, title, by, paid, amount, err) }()
+	info, err := p.yt.getVideoInfo(ctx, vid)
 	if err != nil {
 		return err
 	}
-	if !info.Embeddable {
-		return fmt.Errorf("video is not available for playback")
+	title = info.Title
+	if err := checkPlayable(info); err != nil {
+		return err
 	}
 	cfg := p.cfg.get()
+	policy := policyRequests
+	if paid {
+		policy = policyDonations
+	}
+	if err := cfg.categoryPolicy(policy).check(info.CategoryId); err != nil {
+		return err
+	}
+	if err := cfg.checkRestrictions(info.Restrictions); err != nil {
+		return err
+	}
 	t := &Track{
 		VideoID:     vid,
 		Title:       info.Title,
 		DurationSec: info.Duration,
 		Views:       info.Views,
+		Stale:       info.Stale,
+		VideoMeta:   info.VideoMeta,
 		AddedAt:     time.Now(),
 		AddedBy:     by,
 		IsPaid:      paid,
+		Amount:      amount,
+	}
+	if err := t.clip(ref); err != nil {
+		return err
 	}
-	if cfg.MaxDurationMinutes > 0 && t.DurationSec > cfg.MaxDurationMinutes*60 {
+	if cfg.MaxDurationMinutes > 0 && t.playLength() > cfg.MaxDurationMinutes*60 {
 		return fmt.Errorf("track too long (max %d minutes)", cfg.MaxDurationMinutes)
 	}
 	if cfg.MinViews > 0 && t.Views < cfg.MinViews {
@@ -96,9 +204,13 @@ func (p *Player) validateAndAdd(vid, by string, paid bool) error {
 	if cfg.MaxQueueSize > 0 && p.q.total() >= cfg.MaxQueueSize {
 		return fmt.Errorf("queue is full (max %d tracks)", cfg.MaxQueueSize)
 	}
+	if err := p.checkUserLimitsLocked(cfg, by, paid); err != nil {
+		return err
+	}
 	hadNoCurrent := p.q.current() == nil
-	p.q.add(t)
-	log.Printf("Added: %s by %s (paid=%v)", t.Title, by, paid)
+	p.enqueueLocked(cfg, t)
+	p.requests[newUserKey(by, paid)]++
+	log.Printf("Added: %s by %s (paid=%v, stale=%v)", t.Title, by, paid, t.Stale)
 	if p.state == "stopped" && hadNoCurrent {
 		p.state = "playing"
 	}
@@ -110,6 +222,10 @@ func (p *Player) play() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.q.current() != nil {
+		// Fold in the time played so far before restarting the clock,
+		// or a repeated play would rewind to the last pause.
+		p.elapsed = p.elapsedLocked()
+		p.elapsedAt = time.Now()
 		p.state = "playing"
 		p.broadcast()
 		return nil
@@ -130,6 +246,8 @@ func (p *Player) pause() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.state != "paused" {
+		p.elapsed = p.elapsedLocked()
+		p.elapsedAt = time.Now()
 		p.state = "paused"
 		log.Println("Paused")
 		p.broadcast()
@@ -140,6 +258,8 @@ func (p *Player) stop() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.state != "stopped" {
+		p.elapsed = p.elapsedLocked()
+		p.elapsedAt = time.Now()
 		p.state = "stopped"
 		if p.pl != nil {
 			p.pl.disable()
@@ -149,9 +269,15 @@ func (p *Player) stop() {
 	}
 }
 
-func (p *Player) next() {
+// next advances the queue. outcome records how the current track ended.
+func (p *Player) next(outcome string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.nextLocked(outcome)
+}
+
+func (p *Player) nextLocked(outcome string) {
+	p.endOutcome = outcome
 	if t := p.q.advance(); t != nil {
 		p.state = "playing"
 		log.Printf("Next: %s", t.Title)
@@ -174,6 +300,49 @@ func (p *Player) next() {
 	p.broadcast()
 }
 
+const watchdogInterval = 5 * time.Second
+
+// watchdog advances past a track that has been playing well beyond its
+// duration while no overlay reports progress, e.g. when the OBS browser
+// source is hidden or crashed and never calls /api/next.
+func (p *Player) watchdog() {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.watchdogTick(watchdogInterval)
+	}
+}
+
+func (p *Player) watchdogTick(step time.Duration) {
+	grace := p.cfg.get().WatchdogGraceSec
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.q.current()
+	if cur != p.wdTrack {
+		p.wdTrack = cur
+		p.wdPlayed = 0
+	}
+	if cur == nil || p.state != "playing" {
+		return
+	}
+	p.wdPlayed += step
+	if grace < 0 || cur.DurationSec <= 0 {
+		return
+	}
+	g := time.Duration(grace) * time.Second
+	if p.wdPlayed < time.Duration(cur.playLength())*time.Second+g {
+		return
+	}
+	if !p.heartbeatAt.IsZero() && time.Since(p.heartbeatAt) < g {
+		return
+	}
+	reason := fmt.Sprintf("watchdog: %s exceeded its duration with no overlay heartbeat", cur.Title)
+	log.Printf("Watchdog advancing: %s", reason)
+	p.advanceReason = reason
+	p.nextLocked(outcomeCompleted)
+	p.advanceReason = ""
+}
+
 func (p *Player) previous() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -218,6 +387,69 @@ func (p *Player) remove(idx int) (*Track, error) {
 	return t, nil
 }
 
+func (p *Player) move(from, to int, force bool) (*Track, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, err := p.q.move(from, to, force)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Moved: %s (%d -> %d)", t.Title, from, to)
+	p.broadcast()
+	return t, nil
+}
+
+// enqueueLocked places a track according to the paid and free ordering modes.
+func (p *Player) enqueueLocked(cfg Config, t *Track) {
+	switch {
+	case t.IsPaid && cfg.PaidOrder == paidOrderAmount:
+		p.q.addByAmount(t)
+	case !t.IsPaid && p.orderModeLocked() == orderFair:
+		p.q.addFair(t)
+	default:
+		p.q.add(t)
+	}
+}
+
+// orderModeLocked returns the mode last set, through the API or the
+// config: an API override lapses once queue_order is edited.
+func (p *Player) orderModeLocked() string {
+	cfg := p.cfg.get()
+	if p.orderMode != "" && cfg.QueueOrder != p.orderModeConfig {
+		log.Printf("Queue order override %s dropped: queue_order changed in config", p.orderMode)
+		p.orderMode, p.orderModeConfig = "", ""
+	}
+	if p.orderMode != "" {
+		return p.orderMode
+	}
+	if cfg.QueueOrder == orderFair {
+		return orderFair
+	}
+	return orderFIFO
+}
+
+func (p *Player) getOrderMode() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.orderModeLocked()
+}
+
+func (p *Player) setOrderMode(mode string) error {
+	if mode != orderFIFO && mode != orderFair {
+		return fmt.Errorf("invalid order mode, use %s or %s", orderFIFO, orderFair)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.orderMode = mode
+	p.orderModeConfig = p.cfg.get().QueueOrder
+	if mode == orderFair {
+		p.q.rebalanceFair()
+	}
+	log.Printf("Queue order: %s", mode)
+	p.broadcast()
+	return nil
+}
+
 func (p *Player) clear() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -273,6 +505,83 @@ func (p *Player) cleanupOld(hours int) {
 	p.broadcast()
 }
 
+// startSession begins a stream session; per-user request counts reset.
+func (p *Player) startSession() (Session, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, started := p.sessions.start()
+	if started {
+		clear(p.requests)
+	}
+	return s, started
+}
+
+// endSession closes the stream session; per-user request counts reset.
+func (p *Player) endSession() (SessionSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sum, err := p.sessions.end()
+	if err == nil {
+		clear(p.requests)
+	}
+	return sum, err
+}
+
+// reportProgress records the playback position sent by an overlay.
+// Reports for anything but the current track are ignored.
+func (p *Player) reportProgress(vid string, elapsed float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.q.current()
+	if cur == nil || cur.VideoID != vid || elapsed < 0 {
+		return
+	}
+	p.posTrack = cur
+	p.elapsed = elapsed
+	p.elapsedAt = time.Now()
+	p.heartbeatAt = p.elapsedAt
+}
+
+func (p *Player) seek(sec float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.q.current()
+	if cur == nil {
+		return fmt.Errorf("nothing is playing")
+	}
+	if sec < 0 || (cur.DurationSec > 0 && sec >= float64(cur.playEnd())) {
+		return fmt.Errorf("position out of range")
+	}
+	p.posTrack = cur
+	p.elapsed = sec
+	p.elapsedAt = time.Now()
+	p.seekSeq++
+	log.Printf("Seek: %s to %.0fs", cur.Title, sec)
+	p.broadcast()
+	return nil
+}
+
+// elapsedLocked returns the current track's position in the video in
+// seconds, extrapolated from the last report while playing. Before the
+// first report it is the track's start offset.
+func (p *Player) elapsedLocked() float64 {
+	cur := p.q.current()
+	if cur == nil {
+		return 0
+	}
+	if cur != p.posTrack {
+		return float64(cur.StartSec)
+	}
+	e := p.elapsed
+	if p.state == "playing" && !p.elapsedAt.IsZero() {
+		e += time.Since(p.elapsedAt).Seconds()
+	}
+	if cur.DurationSec > 0 && e > float64(cur.playEnd()) {
+		e = float64(cur.playEnd())
+	}
+	return e
+}
+
 func (p *Player) currentState() PlayerState {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -287,6 +596,7 @@ func (p *Player) status() map[string]any {
 		"current":      p.q.current(),
 		"position":     p.q.cursor + 1,
 		"queue_length": p.q.total(),
+		"elapsed":      p.elapsedLocked(),
 	}
 }
 
@@ -298,41 +608,65 @@ func (p *Player) nowPlaying() map[string]any {
 	if cur == nil {
 		return resp
 	}
-	full := cur.Title
-	art, tit := "", full
-	if before, after, ok := strings.Cut(full, " - "); ok {
-		art = before
-		tit = after
-	}
-	resp["artist"] = art
-	resp["title"] = tit
-	resp["full_title"] = full
+	tt := cur.title(p.cfg.get().titleNormalizer())
+	resp["artist"] = tt.Artist
+	resp["title"] = tt.Song
+	resp["full_title"] = cur.Title
+	resp["clean_title"] = tt.Clean
+	resp["featured"] = tt.Featured
+	if tt.Featured == nil {
+		resp["featured"] = []string{}
+	}
+	resp["channel"] = cur.ChannelTitle
+	resp["thumbnail"] = cur.Thumbnail
 	resp["url"] = fmt.Sprintf("https://www.youtube.com/watch?v=%s", cur.VideoID)
+	resp["elapsed"] = p.elapsedLocked()
+	resp["duration"] = cur.DurationSec
+	resp["start"] = cur.StartSec
+	resp["end"] = cur.playEnd()
 	return resp
 }
 
-func (p *Player) approveTrack(vid, by string) error {
-	info, err := p.yt.getVideoInfoForce(vid)
+func (p *Player) approveTrack(ctx context.Context, ref VideoRef, by string, amount int) (err error) {
+	vid := ref.ID
+	title := vid
+	// The approved track counts as an accepted request, next to the
+	// rejection that sent it to moderation.
+	defer func() {
+		if err == nil {
+			p.recordRequest(vid, title, by, true, amount, nil)
+		}
+	}()
+	// Approval overrides the category policy; playability still applies.
+	info, err := p.yt.getVideoInfo(ctx, vid)
 	if err != nil {
 		return err
 	}
-	if !info.Embeddable {
-		return fmt.Errorf("video is not available for playback")
+	title = info.Title
+	if err := checkPlayable(info); err != nil {
+		return err
 	}
 	t := &Track{
 		VideoID:     vid,
 		Title:       info.Title,
 		DurationSec: info.Duration,
 		Views:       info.Views,
+		Stale:       info.Stale,
+		VideoMeta:   info.VideoMeta,
 		AddedAt:     time.Now(),
 		AddedBy:     by,
 		IsPaid:      true,
+		Amount:      amount,
+	}
+	if err := t.clip(ref); err != nil {
+		return err
 	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	hadNoCurrent := p.q.current() == nil
-	p.q.add(t)
-	log.Printf("Approved donation track: %s by %s", t.Title, by)
+	p.enqueueLocked(p.cfg.get(), t)
+	p.requests[newUserKey(by, true)]++
+	log.Printf("Approved donation track: %s by %s (stale=%v)", t.Title, by, t.Stale)
 	if p.state == "stopped" && hadNoCurrent {
 		p.state = "playing"
 	}
@@ -340,6 +674,53 @@ func (p *Player) approveTrack(vid, by string) error {
 	return nil
 }
 
+// recordRequest logs an add attempt for /api/stats. Attempts that failed
+// only because YouTube was unavailable are not rejections and are skipped;
+// donations are retried and recorded once they get an answer.
+func (p *Player) recordRequest(vid, title, by string, paid bool, amount int, err error) {
+	if p.store == nil || errors.Is(err, errYouTubeUnavailable) {
+		return
+	}
+	e := RequestEvent{
+		At:       time.Now(),
+		VideoID:  vid,
+		Title:    title,
+		User:     by,
+		IsPaid:   paid,
+		Amount:   amount,
+		Accepted: err == nil,
+	}
+	if err != nil {
+		e.Rule = rejectionRule(err)
+	}
+	p.store.addRequestEvent(e)
+}
+
+// checkUserLimitsLocked enforces the per-user upcoming and per-session
+// request limits. Paid and free requests are limited separately.
+func (p *Player) checkUserLimitsLocked(cfg Config, by string, paid bool) error {
+	maxQueued, maxRequests := cfg.UserMaxQueuedFree, cfg.UserMaxRequestsFree
+	if paid {
+		maxQueued, maxRequests = cfg.UserMaxQueuedPaid, cfg.UserMaxRequestsPaid
+	}
+	key := newUserKey(by, paid)
+	if maxQueued > 0 {
+		n := 0
+		for i := p.q.cursor + 1; i < len(p.q.items); i++ {
+			if t := p.q.items[i]; t.IsPaid == paid && newUserKey(t.AddedBy, paid) == key {
+				n++
+			}
+		}
+		if n >= maxQueued {
+			return fmt.Errorf("%w (max %d upcoming tracks per user)", errUserQueueLimit, maxQueued)
+		}
+	}
+	if maxRequests > 0 && p.requests[key] >= maxRequests {
+		return fmt.Errorf("%w (max %d requests per session)", errUserRequestLimit, maxRequests)
+	}
+	return nil
+}
+
 func (p *Player) canRepeat(id string) bool {
 	limit := p.cfg.get().RepeatLimit
 	if limit == 0 {
@@ -356,12 +737,71 @@ func (p *Player) canRepeat(id string) bool {
 
 func (p *Player) broadcast() {
 	st := p.buildState()
+	// A session starts automatically when playback starts, not while it
+	// merely continues, so ending a session mid-track does not reopen it.
+	if p.state == "playing" && p.lastState != "playing" && p.sessions != nil {
+		p.sessions.start()
+	}
+	p.lastState = p.state
+	p.recordHistoryLocked()
+	p.persist()
 	select {
 	case p.updates <- st:
 	default:
 	}
 }
 
+// recordHistoryLocked writes the previous track to the history store once
+// it is no longer current.
+func (p *Player) recordHistoryLocked() {
+	cur := p.q.current()
+	if cur == p.histTrack {
+		p.endOutcome = ""
+		return
+	}
+	if prev := p.histTrack; prev != nil && p.store != nil {
+		outcome := p.endOutcome
+		if outcome == "" {
+			outcome = outcomeSkipped
+		}
+		p.store.addHistory(HistoryEntry{
+			VideoID:     prev.VideoID,
+			Title:       prev.Title,
+			DurationSec: prev.playLength(),
+			AddedBy:     prev.AddedBy,
+			IsPaid:      prev.IsPaid,
+			Amount:      prev.Amount,
+			Source:      trackSource(prev),
+			StartedAt:   p.histStart,
+			EndedAt:     time.Now(),
+			Outcome:     outcome,
+		})
+	}
+	p.endOutcome = ""
+	p.histTrack = cur
+	p.histStart = time.Now()
+}
+
+func (p *Player) persist() {
+	if p.store == nil {
+		return
+	}
+	snap := PlayerSnapshot{
+		Items:           p.q.snapshot(),
+		Cursor:          p.q.cursor,
+		State:           p.state,
+		OrderMode:       p.orderMode,
+		OrderModeConfig: p.orderModeConfig,
+		Elapsed:         p.elapsedLocked(),
+	}
+	// Keep the restored playlist position until the playlist finishes loading.
+	if p.pl != nil && p.pl.loaded() {
+		p.plSnap = p.pl.snapshot()
+	}
+	snap.Playlist = p.plSnap
+	p.store.setState(snap)
+}
+
 func (p *Player) buildState() PlayerState {
 	var plState PlaylistStatus
 	if p.pl != nil {
@@ -374,11 +814,22 @@ func (p *Player) buildState() PlayerState {
 			CurrentIndex: p.pl.activeTrackIndex(),
 		}
 	}
+	cur := p.q.current()
+	var curTitle *TrackTitle
+	if cur != nil {
+		tt := cur.title(p.cfg.get().titleNormalizer())
+		curTitle = &tt
+	}
 	return PlayerState{
-		Action:   p.state,
-		Current:  p.q.current(),
-		Queue:    p.q.snapshot(),
-		Position: p.q.cursor,
-		Playlist: plState,
+		Action:        p.state,
+		Current:       cur,
+		CurrentTitle:  curTitle,
+		Queue:         p.q.snapshot(),
+		Position:      p.q.cursor,
+		Elapsed:       p.elapsedLocked(),
+		SeekSeq:       p.seekSeq,
+		AdvanceReason: p.advanceReason,
+		QueueOrder:    p.orderModeLocked(),
+		Playlist:      plState,
 	}
 }
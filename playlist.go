@@ -1,11 +1,10 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
-	"net/http"
 	"net/url"
 	"sync"
 	"time"
@@ -19,31 +18,23 @@ type Playlist struct {
 	currentIndex int
 	isShuffled   bool
 	isEnabled    bool
-	yt           *YouTubeClient
+	manual       bool
+	yt           MetadataProvider
 	cache        *Cache
+	cfg          *ConfigManager
 }
 
-type playlistAPIResponse struct {
-	Items []struct {
-		Snippet struct {
-			ResourceID struct {
-				VideoID string `json:"videoId"`
-			} `json:"resourceId"`
-		} `json:"snippet"`
-	} `json:"items"`
-	NextPageToken string `json:"nextPageToken"`
-}
-
-func newPlaylist(yt *YouTubeClient, c *Cache) *Playlist {
+func newPlaylist(yt MetadataProvider, c *Cache, cfg *ConfigManager) *Playlist {
 	return &Playlist{
 		tracks:       make([]*Track, 0),
 		currentIndex: -1,
 		yt:           yt,
 		cache:        c,
+		cfg:          cfg,
 	}
 }
 
-func (pl *Playlist) load(playlistURL string) error {
+func (pl *Playlist) load(ctx context.Context, playlistURL string) error {
 	pid := extractPlaylistID(playlistURL)
 	if pid == "" {
 		return fmt.Errorf("invalid playlist URL")
@@ -56,129 +47,105 @@ func (pl *Playlist) load(playlistURL string) error {
 
 	if entry, ok := pl.cache.getPlaylist(pid); ok {
 		log.Printf("Playlist loaded from cache: %d tracks", len(entry.Tracks))
+		cfg := pl.cfg.get()
+		policy := cfg.categoryPolicy(policyPlaylist)
 		pl.mu.Lock()
 		for _, t := range entry.Tracks {
-			if !t.Embeddable {
+			if !t.Embeddable || t.DurationSec <= 0 || policy.check(t.CategoryId) != nil || cfg.checkRestrictions(t.Restrictions) != nil {
 				continue
 			}
 			pl.tracks = append(pl.tracks, &Track{
-				VideoID:     t.VideoID,
-				Title:       t.Title,
-				DurationSec: t.DurationSec,
-				Views:       t.Views,
-				AddedAt:     time.Now(),
-				AddedBy:     "Playlist",
+				VideoID:      t.VideoID,
+				Title:        t.Title,
+				DurationSec:  t.DurationSec,
+				Views:        t.Views,
+				VideoMeta:    t.VideoMeta,
+				AddedAt:      time.Now(),
+				AddedBy:      "Playlist",
+				FromPlaylist: true,
 			})
 		}
 		pl.buildOrderLocked()
 		pl.mu.Unlock()
 		return nil
 	}
-	return pl.fetchAndCache(pid)
+	return pl.fetchAndCache(ctx, pid)
 }
 
-func (pl *Playlist) reload(playlistURL string) error {
+func (pl *Playlist) reload(ctx context.Context, playlistURL string) error {
 	pid := extractPlaylistID(playlistURL)
 	if pid == "" {
 		return fmt.Errorf("invalid playlist URL")
 	}
 	pl.cache.deletePlaylist(pid)
-	return pl.load(playlistURL)
+	return pl.load(ctx, playlistURL)
 }
 
-func (pl *Playlist) fetchAndCache(pid string) error {
-	vids, err := pl.fetchAllVideoIDs(pid)
+func (pl *Playlist) fetchAndCache(ctx context.Context, pid string) error {
+	vids, err := pl.yt.playlistVideoIDs(ctx, pid)
 	if err != nil {
 		return err
 	}
-	client := &http.Client{Timeout: 20 * time.Second}
+	infos, lookupErr := pl.yt.getVideoInfoBatch(ctx, vids)
+	if lookupErr != nil {
+		if len(infos) == 0 {
+			return lookupErr
+		}
+		log.Printf("Playlist lookup incomplete, not caching: %v", lookupErr)
+	}
+	// Every playable track is cached with its category so that a policy
+	// change applies without refetching.
+	cfg := pl.cfg.get()
+	policy := cfg.categoryPolicy(policyPlaylist)
 	var cTracks []PlaylistTrack
 	ok, fail := 0, 0
 	for _, vid := range vids {
-		info, err := pl.yt.getVideoInfoWithClient(vid, client)
-		if err != nil || !info.Embeddable {
+		info, found := infos[vid]
+		if !found || checkPlayable(info) != nil {
+			fail++
+			continue
+		}
+		cTracks = append(cTracks, PlaylistTrack{
+			VideoID:      vid,
+			Title:        info.Title,
+			DurationSec:  info.Duration,
+			Views:        info.Views,
+			Embeddable:   true,
+			CategoryId:   info.CategoryId,
+			Restrictions: info.Restrictions,
+			VideoMeta:    info.VideoMeta,
+		})
+		if policy.check(info.CategoryId) != nil || cfg.checkRestrictions(info.Restrictions) != nil {
 			fail++
 			continue
 		}
 		pl.mu.Lock()
 		pl.tracks = append(pl.tracks, &Track{
-			VideoID:     vid,
-			Title:       info.Title,
-			DurationSec: info.Duration,
-			Views:       info.Views,
-			AddedAt:     time.Now(),
-			AddedBy:     "Playlist",
+			VideoID:      vid,
+			Title:        info.Title,
+			DurationSec:  info.Duration,
+			Views:        info.Views,
+			VideoMeta:    info.VideoMeta,
+			AddedAt:      time.Now(),
+			AddedBy:      "Playlist",
+			FromPlaylist: true,
 		})
 		pl.mu.Unlock()
-		cTracks = append(cTracks, PlaylistTrack{
-			VideoID:     vid,
-			Title:       info.Title,
-			DurationSec: info.Duration,
-			Views:       info.Views,
-			Embeddable:  true,
-			CategoryId:  "10",
-		})
 		ok++
 	}
 	if ok == 0 {
 		return fmt.Errorf("no valid tracks found in playlist")
 	}
 	log.Printf("Loaded playlist: %d tracks (%d skipped)", ok, fail)
-	pl.cache.setPlaylist(pid, PlaylistEntry{Tracks: cTracks})
+	if lookupErr == nil {
+		pl.cache.setPlaylist(pid, PlaylistEntry{Tracks: cTracks})
+	}
 	pl.mu.Lock()
 	pl.buildOrderLocked()
 	pl.mu.Unlock()
 	return nil
 }
 
-func (pl *Playlist) fetchAllVideoIDs(pid string) ([]string, error) {
-	if pl.yt.apiKey == "" {
-		return nil, fmt.Errorf("YouTube API key not configured")
-	}
-	client := &http.Client{Timeout: 20 * time.Second}
-	var vids []string
-	pageToken := ""
-	for {
-		u := fmt.Sprintf(
-			"https://www.googleapis.com/youtube/v3/playlistItems?part=snippet&playlistId=%s&maxResults=50&key=%s",
-			pid, pl.yt.apiKey,
-		)
-		if pageToken != "" {
-			u += "&pageToken=" + pageToken
-		}
-		page, err := fetchPlaylistPage(client, u)
-		if err != nil {
-			return nil, err
-		}
-		for _, item := range page.Items {
-			if vid := item.Snippet.ResourceID.VideoID; vid != "" {
-				vids = append(vids, vid)
-			}
-		}
-		if page.NextPageToken == "" {
-			break
-		}
-		pageToken = page.NextPageToken
-	}
-	return vids, nil
-}
-
-func fetchPlaylistPage(client *http.Client, u string) (*playlistAPIResponse, error) {
-	resp, err := client.Get(u)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("youtube API returned status: %d", resp.StatusCode)
-	}
-	var ar playlistAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
-		return nil, fmt.Errorf("failed to parse API response: %w", err)
-	}
-	return &ar, nil
-}
-
 func extractPlaylistID(rawURL string) string {
 	if u, err := url.Parse(rawURL); err == nil {
 		if pid := u.Query().Get("list"); len(pid) >= 2 && pid[:2] == "PL" {
@@ -261,12 +228,14 @@ func (pl *Playlist) trackAtLocked(pos int) *Track {
 	}
 	src := pl.tracks[idx]
 	return &Track{
-		VideoID:     src.VideoID,
-		Title:       src.Title,
-		DurationSec: src.DurationSec,
-		Views:       src.Views,
-		AddedAt:     time.Now(),
-		AddedBy:     "Playlist",
+		VideoID:      src.VideoID,
+		Title:        src.Title,
+		DurationSec:  src.DurationSec,
+		Views:        src.Views,
+		VideoMeta:    src.VideoMeta,
+		AddedAt:      time.Now(),
+		AddedBy:      "Playlist",
+		FromPlaylist: true,
 	}
 }
 
@@ -292,6 +261,50 @@ func (pl *Playlist) buildOrderLocked() {
 	rng.Shuffle(n, func(i, j int) { pl.order[i], pl.order[j] = pl.order[j], pl.order[i] })
 }
 
+func (pl *Playlist) snapshot() PlaylistSnapshot {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+	order := make([]int, len(pl.order))
+	copy(order, pl.order)
+	return PlaylistSnapshot{
+		PlaylistID:   pl.playlistID,
+		CurrentIndex: pl.currentIndex,
+		Order:        order,
+		Shuffled:     pl.isShuffled,
+		Enabled:      pl.isEnabled,
+		Manual:       pl.manual,
+	}
+}
+
+// restorePosition reapplies a saved position after the playlist has been
+// loaded. The saved shuffle order is only reused if it still fits.
+func (pl *Playlist) restorePosition(s PlaylistSnapshot) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if s.PlaylistID != pl.playlistID || len(pl.tracks) == 0 {
+		return
+	}
+	pl.isShuffled = s.Shuffled
+	if s.Shuffled && len(s.Order) == len(pl.tracks) {
+		pl.order = s.Order
+	} else if s.Shuffled {
+		pl.buildOrderLocked()
+	}
+	if s.CurrentIndex >= -1 && s.CurrentIndex < len(pl.tracks) {
+		pl.currentIndex = s.CurrentIndex
+	}
+	pl.isEnabled = s.Enabled
+	pl.manual = s.Manual
+}
+
+// setManual marks the playlist as chosen through the API, so that it is
+// restored after a restart instead of fallback_playlist_url.
+func (pl *Playlist) setManual() {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.manual = true
+}
+
 func (pl *Playlist) enable() {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
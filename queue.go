@@ -1,6 +1,19 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	orderFIFO = "fifo"
+	orderFair = "fair"
+
+	paidOrderArrival = "arrival"
+	paidOrderAmount  = "amount"
+)
 
 type Track struct {
 	VideoID     string    `json:"video_id"`
@@ -10,6 +23,52 @@ type Track struct {
 	AddedAt     time.Time `json:"added_at"`
 	AddedBy     string    `json:"added_by,omitempty"`
 	IsPaid      bool      `json:"is_paid"`
+	Amount      int       `json:"amount,omitempty"`
+	// StartSec and EndSec clip playback to part of the video. EndSec 0
+	// means the end of the video.
+	StartSec int `json:"start_sec,omitempty"`
+	EndSec   int `json:"end_sec,omitempty"`
+	// Stale is set when the track was checked against expired cached
+	// metadata because YouTube could not be reached.
+	Stale bool `json:"stale,omitempty"`
+	// FromPlaylist marks tracks taken from the fallback playlist rather
+	// than requested by a viewer.
+	FromPlaylist bool `json:"from_playlist,omitempty"`
+	VideoMeta
+}
+
+func (t *Track) title(n *TitleNormalizer) TrackTitle {
+	return n.normalize(t.Title, t.ChannelTitle)
+}
+
+// playEnd is the position, in seconds, where playback of t stops.
+func (t *Track) playEnd() int {
+	if t.EndSec > 0 {
+		return t.EndSec
+	}
+	return t.DurationSec
+}
+
+// playLength is how long t plays once clipped to its offsets.
+func (t *Track) playLength() int { return t.playEnd() - t.StartSec }
+
+// clip applies the offsets of ref to t. An end offset at or past the end
+// of the video is dropped.
+func (t *Track) clip(ref VideoRef) error {
+	if ref.StartSec < 0 || ref.EndSec < 0 {
+		return fmt.Errorf("invalid offset")
+	}
+	if t.DurationSec > 0 && ref.StartSec >= t.DurationSec {
+		return fmt.Errorf("start offset is past the end of the video")
+	}
+	if ref.EndSec > 0 && ref.EndSec <= ref.StartSec {
+		return fmt.Errorf("end offset must be after start offset")
+	}
+	t.StartSec, t.EndSec = ref.StartSec, ref.EndSec
+	if t.DurationSec > 0 && t.EndSec >= t.DurationSec {
+		t.EndSec = 0
+	}
+	return nil
 }
 
 type Queue struct {
@@ -36,6 +95,75 @@ func (q *Queue) add(t *Track) {
 	}
 }
 
+// addByAmount inserts a paid track into the paid block after every paid
+// track with the same or a larger donation, so bigger donations play
+// sooner and equal amounts keep arrival order.
+func (q *Queue) addByAmount(t *Track) {
+	if !t.IsPaid {
+		q.add(t)
+		return
+	}
+	pos := min(q.cursor+1, len(q.items))
+	for pos < len(q.items) && q.items[pos].IsPaid && q.items[pos].Amount >= t.Amount {
+		pos++
+	}
+	q.items = append(q.items[:pos], append([]*Track{t}, q.items[pos:]...)...)
+}
+
+// addFair inserts a free track so that the upcoming free part of the queue
+// interleaves requesters: each user gets one slot per round. Paid tracks
+// still go through add.
+func (q *Queue) addFair(t *Track) {
+	if t.IsPaid {
+		q.add(t)
+		return
+	}
+	start := q.cursor + 1 + q.paidBlockLen()
+	if start > len(q.items) {
+		start = len(q.items)
+	}
+	by := strings.ToLower(t.AddedBy)
+	seen := make(map[string]int)
+	for i := start; i < len(q.items); i++ {
+		if !q.items[i].IsPaid {
+			seen[strings.ToLower(q.items[i].AddedBy)]++
+		}
+	}
+	round := seen[by]
+	clear(seen)
+	pos := start
+	for i := start; i < len(q.items); i++ {
+		it := q.items[i]
+		if it.IsPaid {
+			continue
+		}
+		u := strings.ToLower(it.AddedBy)
+		if seen[u] <= round {
+			pos = i + 1
+		}
+		seen[u]++
+	}
+	q.items = append(q.items[:pos], append([]*Track{t}, q.items[pos:]...)...)
+}
+
+// rebalanceFair reorders the upcoming free tracks into rounds by requester,
+// keeping each user's own tracks in their existing order.
+func (q *Queue) rebalanceFair() {
+	start := q.cursor + 1 + q.paidBlockLen()
+	if start >= len(q.items) {
+		return
+	}
+	tail := q.items[start:]
+	rounds := make(map[*Track]int, len(tail))
+	seen := make(map[string]int)
+	for _, it := range tail {
+		u := strings.ToLower(it.AddedBy)
+		rounds[it] = seen[u]
+		seen[u]++
+	}
+	sort.SliceStable(tail, func(i, j int) bool { return rounds[tail[i]] < rounds[tail[j]] })
+}
+
 func (q *Queue) advance() *Track {
 	if q.cursor+1 >= len(q.items) {
 		return nil
@@ -62,6 +190,47 @@ func (q *Queue) removeAt(relIdx int) *Track {
 	return t
 }
 
+// paidBlockLen returns how many tracks at the head of the upcoming part
+// of the queue are paid, i.e. the block add places paid tracks into.
+func (q *Queue) paidBlockLen() int {
+	n := 0
+	for i := q.cursor + 1; i < len(q.items) && q.items[i].IsPaid; i++ {
+		n++
+	}
+	return n
+}
+
+// move relocates an upcoming track. Indices are relative to the cursor,
+// as in removeAt. Unless force is set, free tracks may not jump above the
+// paid block and paid tracks may not drop below the first free track.
+func (q *Queue) move(from, to int, force bool) (*Track, error) {
+	base := q.cursor + 1
+	upcoming := len(q.items) - base
+	if from < 0 || from >= upcoming || to < 0 || to >= upcoming {
+		return nil, fmt.Errorf("index out of range")
+	}
+	t := q.items[base+from]
+	if !t.IsPaid && !force && to < q.paidBlockLen() {
+		return nil, fmt.Errorf("free tracks cannot be moved above paid tracks")
+	}
+	if t.IsPaid && !force {
+		// The first free track's index once t is taken out.
+		firstFree := q.paidBlockLen()
+		if from < firstFree {
+			firstFree--
+		}
+		if to > firstFree {
+			return nil, fmt.Errorf("paid tracks cannot be moved below free tracks")
+		}
+	}
+	if from == to {
+		return t, nil
+	}
+	rest := append(q.items[:base+from:base+from], q.items[base+from+1:]...)
+	q.items = append(rest[:base+to], append([]*Track{t}, rest[base+to:]...)...)
+	return t, nil
+}
+
 func (q *Queue) resetCursor() int {
 	n := q.cursor
 	if n > 0 {
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	_ "time/tzdata" // Pacific time must resolve on Windows too
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// YouTube Data API quota costs per call.
+const (
+	quotaCostList   = 1
+	quotaCostSearch = 100
+
+	defaultDailyQuota = 10000
+)
+
+var errQuotaReserve = errors.New("quota reserve reached, non-essential call blocked")
+
+var quotaLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		log.Printf("Pacific time zone unavailable, quota resets at UTC midnight: %v", err)
+		return time.UTC
+	}
+	return loc
+}()
+
+type QuotaStatus struct {
+	Date      string    `json:"date"`
+	Used      int       `json:"used"`
+	Limit     int       `json:"limit"`
+	Reserve   int       `json:"reserve"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// QuotaTracker counts API units spent per Pacific day (the YouTube quota
+// day) and persists the counter so restarts do not lose it.
+type QuotaTracker struct {
+	mu    sync.Mutex
+	store *Cache
+	cfg   *ConfigManager
+	day   string
+	used  int
+}
+
+func newQuotaTracker(store *Cache, cfg *ConfigManager) *QuotaTracker {
+	q := &QuotaTracker{store: store, cfg: cfg}
+	q.day = quotaDay(time.Now())
+	q.used = store.getQuotaUsed(q.day)
+	return q
+}
+
+func quotaDay(t time.Time) string { return t.In(quotaLocation).Format("2006-01-02") }
+
+// rolloverLocked resets the counter when the Pacific day has changed.
+func (q *QuotaTracker) rolloverLocked() {
+	if d := quotaDay(time.Now()); d != q.day {
+		q.day = d
+		q.used = 0
+	}
+}
+
+func (q *QuotaTracker) limits() (limit, reserve int) {
+	c := q.cfg.get()
+	return c.YouTubeDailyQuota * max(len(c.apiKeys()), 1), c.YouTubeQuotaReserve
+}
+
+// allow checks whether a call costing units may be made. Essential calls
+// (viewer requests) are never blocked here; non-essential ones (playlist
+// loads, manual searches) may not dip into the configured reserve.
+func (q *QuotaTracker) allow(units int, essential bool) error {
+	if essential {
+		return nil
+	}
+	limit, reserve := q.limits()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rolloverLocked()
+	if q.used+units > limit-reserve {
+		return fmt.Errorf("%w (%d/%d units used)", errQuotaReserve, q.used, limit)
+	}
+	return nil
+}
+
+// spend records units for a call that reached the API.
+func (q *QuotaTracker) spend(units int) {
+	q.mu.Lock()
+	q.rolloverLocked()
+	q.used += units
+	day, used := q.day, q.used
+	q.mu.Unlock()
+	q.store.setQuotaUsed(day, used)
+}
+
+func (q *QuotaTracker) status() QuotaStatus {
+	limit, reserve := q.limits()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rolloverLocked()
+	now := time.Now().In(quotaLocation)
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, quotaLocation)
+	return QuotaStatus{
+		Date:      q.day,
+		Used:      q.used,
+		Limit:     limit,
+		Reserve:   reserve,
+		Remaining: max(limit-q.used, 0),
+		ResetsAt:  reset,
+	}
+}
+
+func (c *Cache) getQuotaUsed(day string) int {
+	n := 0
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucketQuota).Get([]byte(day)); b != nil {
+			n, _ = strconv.Atoi(string(b))
+		}
+		return nil
+	})
+	return n
+}
+
+func (c *Cache) setQuotaUsed(day string, used int) {
+	_ = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketQuota).Put([]byte(day), []byte(strconv.Itoa(used)))
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Age restriction policies.
+const (
+	agePolicyReject = "reject"
+	agePolicyAllow  = "allow"
+)
+
+var (
+	errRegionBlocked = errors.New("video is blocked in the streamer's region")
+	errAgeRestricted = errors.New("video is age-restricted")
+	errLiveContent   = errors.New("live streams and premieres cannot be queued")
+	errBadDuration   = errors.New("video duration is unknown")
+)
+
+// Values of snippet.liveBroadcastContent.
+const (
+	broadcastLive     = "live"
+	broadcastUpcoming = "upcoming"
+)
+
+// Restrictions are the viewing limits YouTube reports for a video in
+// contentDetails.regionRestriction and contentDetails.contentRating.
+type Restrictions struct {
+	// RegionAllowed, when non-empty, lists the only countries the video
+	// plays in. RegionBlocked lists countries it does not play in.
+	RegionAllowed []string
+	RegionBlocked []string
+	AgeRestricted bool
+}
+
+func (r Restrictions) blockedIn(region string) bool {
+	if region == "" {
+		return false
+	}
+	if slices.Contains(r.RegionBlocked, region) {
+		return true
+	}
+	return len(r.RegionAllowed) > 0 && !slices.Contains(r.RegionAllowed, region)
+}
+
+// checkPlayable rejects videos that cannot play through in the overlay:
+// not embeddable, live or upcoming, or without a known length.
+func checkPlayable(info VideoInfo) error {
+	if !info.Embeddable {
+		return fmt.Errorf("video is not available for playback")
+	}
+	switch info.Broadcast {
+	case broadcastLive, broadcastUpcoming:
+		return fmt.Errorf("%w (%s)", errLiveContent, info.Broadcast)
+	}
+	if info.Duration <= 0 {
+		return errBadDuration
+	}
+	return nil
+}
+
+// checkRestrictions rejects videos that would not play for the streamer:
+// blocked in StreamerRegion, or age-restricted under the default policy.
+func (c Config) checkRestrictions(r Restrictions) error {
+	if region := strings.ToUpper(c.StreamerRegion); r.blockedIn(region) {
+		return fmt.Errorf("%w (%s)", errRegionBlocked, region)
+	}
+	if r.AgeRestricted && c.AgeRestricted != agePolicyAllow {
+		return errAgeRestricted
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 5
+	maxSearchLimit     = 25
+
+	// maxSearchQueryLen keeps long donation messages from turning into
+	// useless queries.
+	maxSearchQueryLen = 100
+)
+
+type SearchResult struct {
+	VideoID      string  `json:"video_id"`
+	Title        string  `json:"title"`
+	ChannelTitle string  `json:"channel_title"`
+	Confidence   float64 `json:"confidence"`
+}
+
+// search looks up music videos matching query, best match first. It costs
+// quotaCostSearch units; only essential searches may spend the reserve.
+func (c *YouTubeClient) search(ctx context.Context, query string, limit int, essential bool) ([]SearchResult, error) {
+	u := fmt.Sprintf(
+		"%s/search?part=snippet&type=video&videoCategoryId=%s&maxResults=%d&q=%s",
+		c.baseURL, categoryMusic, limit, url.QueryEscape(query),
+	)
+	resp, err := c.get(ctx, u, quotaCostSearch, essential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("youtube API returned status: %d", resp.StatusCode)
+	}
+	var ar struct {
+		Items []struct {
+			ID struct {
+				VideoID string `json:"videoId"`
+			} `json:"id"`
+			Snippet struct {
+				Title        string `json:"title"`
+				ChannelTitle string `json:"channelTitle"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	out := make([]SearchResult, 0, len(ar.Items))
+	for _, item := range ar.Items {
+		if item.ID.VideoID == "" {
+			continue
+		}
+		// Search snippets come HTML-escaped ("Guns N&#39; Roses").
+		r := SearchResult{
+			VideoID:      item.ID.VideoID,
+			Title:        html.UnescapeString(item.Snippet.Title),
+			ChannelTitle: html.UnescapeString(item.Snippet.ChannelTitle),
+		}
+		r.Confidence = matchConfidence(query, r)
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// search matches query words against fixture titles, most viewed first.
+func (f *FakeProvider) search(_ context.Context, query string, limit int, _ bool) ([]SearchResult, error) {
+	type hit struct {
+		r     SearchResult
+		views int
+	}
+	var hits []hit
+	for id, v := range f.videos {
+		if v.CategoryId != categoryMusic {
+			continue
+		}
+		r := SearchResult{VideoID: id, Title: v.Title, ChannelTitle: v.ChannelTitle}
+		r.Confidence = matchConfidence(query, r)
+		if r.Confidence > 0 {
+			hits = append(hits, hit{r, v.Views})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].r.Confidence != hits[j].r.Confidence {
+			return hits[i].r.Confidence > hits[j].r.Confidence
+		}
+		return hits[i].views > hits[j].views
+	})
+	out := make([]SearchResult, 0, min(limit, len(hits)))
+	for _, h := range hits[:min(limit, len(hits))] {
+		out = append(out, h.r)
+	}
+	return out, nil
+}
+
+// matchConfidence estimates how well a result matches what a viewer typed:
+// the share of the query's words found in the result's title and channel
+// name, from 0 to 1.
+func matchConfidence(query string, r SearchResult) float64 {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return 0
+	}
+	have := make(map[string]bool)
+	for _, w := range searchWords(r.Title + " " + r.ChannelTitle) {
+		have[w] = true
+	}
+	found := 0
+	for _, w := range words {
+		if have[w] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(words))
+}
+
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchQuery turns free text (a donation message) into a search query.
+func searchQuery(text string) string {
+	q := strings.Join(strings.Fields(text), " ")
+	if r := []rune(q); len(r) > maxSearchQueryLen {
+		q = string(r[:maxSearchQueryLen])
+	}
+	return q
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const sessionReportDir = "reports"
+
+// Session is one stream. Tracks are not stored on it; they come from the
+// history store by time range when a summary is built.
+type Session struct {
+	ID            string    `json:"id"`
+	StartedAt     time.Time `json:"started_at"`
+	EndedAt       time.Time `json:"ended_at,omitzero"`
+	ModApproved   int       `json:"moderation_approved"`
+	ModRejected   int       `json:"moderation_rejected"`
+	Donations     int       `json:"donations"`
+	DonationTotal int       `json:"donation_total"`
+}
+
+func (s *Session) active() bool { return s.EndedAt.IsZero() }
+
+type SessionSummary struct {
+	ID               string    `json:"id"`
+	Active           bool      `json:"active"`
+	StartedAt        time.Time `json:"started_at"`
+	EndedAt          time.Time `json:"ended_at,omitzero"`
+	TracksPlayed     int       `json:"tracks_played"`
+	Completed        int       `json:"completed"`
+	Skipped          int       `json:"skipped"`
+	Errors           int       `json:"errors"`
+	PlayTimeSec      int       `json:"play_time_sec"`
+	UniqueRequesters int       `json:"unique_requesters"`
+	PaidTracks       int       `json:"paid_tracks"`
+	FreeTracks       int       `json:"free_tracks"`
+	PlaylistTracks   int       `json:"playlist_tracks"`
+	ModApproved      int       `json:"moderation_approved"`
+	ModRejected      int       `json:"moderation_rejected"`
+	Donations        int       `json:"donations"`
+	DonationTotal    int       `json:"donation_total"`
+}
+
+type SessionManager struct {
+	mu    sync.Mutex
+	store *Cache
+	cur   *Session
+}
+
+// newSessionManager resumes the last session if it was never ended.
+func newSessionManager(c *Cache) *SessionManager {
+	m := &SessionManager{store: c}
+	if s, ok := c.lastSession(); ok && s.active() {
+		m.cur = &s
+		log.Printf("Resumed session %s", s.ID)
+	}
+	return m
+}
+
+// start begins a new session. It reports false if one is already active.
+func (m *SessionManager) start() (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cur != nil {
+		return *m.cur, false
+	}
+	now := time.Now()
+	m.cur = &Session{ID: m.newSessionID(now), StartedAt: now}
+	m.store.setSession(*m.cur)
+	log.Printf("Session started: %s", m.cur.ID)
+	return *m.cur, true
+}
+
+// newSessionID names a session after its start time. A session started
+// in the same second as the previous one gets a sequence suffix ("-02"),
+// so it neither overwrites that record and its report files nor breaks
+// the key order lastSession relies on.
+func (m *SessionManager) newSessionID(t time.Time) string {
+	base := t.Format("20060102-150405")
+	id := base
+	for n := 2; ; n++ {
+		if _, exists := m.store.getSession(id); !exists {
+			return id
+		}
+		id = fmt.Sprintf("%s-%02d", base, n)
+	}
+}
+
+// end closes the active session and writes its report files.
+func (m *SessionManager) end() (SessionSummary, error) {
+	m.mu.Lock()
+	if m.cur == nil {
+		m.mu.Unlock()
+		return SessionSummary{}, fmt.Errorf("no active session")
+	}
+	s := *m.cur
+	s.EndedAt = time.Now()
+	m.store.setSession(s)
+	m.cur = nil
+	m.mu.Unlock()
+
+	sum := m.summarize(s)
+	if err := writeSessionReport(sum); err != nil {
+		log.Printf("Failed to write session report: %v", err)
+	}
+	log.Printf("Session ended: %s (%d tracks)", s.ID, sum.TracksPlayed)
+	return sum, nil
+}
+
+func (m *SessionManager) current() (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cur == nil {
+		return Session{}, false
+	}
+	return *m.cur, true
+}
+
+// summary returns the summary of the given session, or of the active (or
+// else the most recent) session when id is empty.
+func (m *SessionManager) summary(id string) (SessionSummary, error) {
+	if id == "" {
+		if s, ok := m.current(); ok {
+			return m.summarize(s), nil
+		}
+		s, ok := m.store.lastSession()
+		if !ok {
+			return SessionSummary{}, fmt.Errorf("no sessions recorded")
+		}
+		return m.summarize(s), nil
+	}
+	if s, ok := m.current(); ok && s.ID == id {
+		return m.summarize(s), nil
+	}
+	s, ok := m.store.getSession(id)
+	if !ok {
+		return SessionSummary{}, fmt.Errorf("session not found")
+	}
+	return m.summarize(s), nil
+}
+
+func (m *SessionManager) update(fn func(s *Session)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cur == nil {
+		return
+	}
+	fn(m.cur)
+	m.store.setSession(*m.cur)
+}
+
+func (m *SessionManager) noteDonation(amount int) {
+	m.update(func(s *Session) {
+		s.Donations++
+		s.DonationTotal += amount
+	})
+}
+
+func (m *SessionManager) noteModeration(approved bool) {
+	m.update(func(s *Session) {
+		if approved {
+			s.ModApproved++
+		} else {
+			s.ModRejected++
+		}
+	})
+}
+
+func (m *SessionManager) summarize(s Session) SessionSummary {
+	f := HistoryFilter{From: s.StartedAt, To: s.EndedAt}
+	items, _ := m.store.queryHistory(f, 0, 0)
+	sum := SessionSummary{
+		ID:            s.ID,
+		Active:        s.active(),
+		StartedAt:     s.StartedAt,
+		EndedAt:       s.EndedAt,
+		TracksPlayed:  len(items),
+		ModApproved:   s.ModApproved,
+		ModRejected:   s.ModRejected,
+		Donations:     s.Donations,
+		DonationTotal: s.DonationTotal,
+	}
+	users := make(map[string]struct{})
+	for _, e := range items {
+		switch e.Outcome {
+		case outcomeCompleted:
+			sum.Completed++
+		case outcomeSkipped:
+			sum.Skipped++
+		case outcomeError:
+			sum.Errors++
+		}
+		played := int(e.EndedAt.Sub(e.StartedAt).Seconds())
+		if e.DurationSec > 0 && played > e.DurationSec {
+			played = e.DurationSec
+		}
+		sum.PlayTimeSec += played
+		switch {
+		case e.IsPaid:
+			sum.PaidTracks++
+		case e.Source == sourcePlaylist:
+			sum.PlaylistTracks++
+		default:
+			sum.FreeTracks++
+		}
+		if e.Source == sourceRequest {
+			users[strings.ToLower(e.AddedBy)] = struct{}{}
+		}
+	}
+	sum.UniqueRequesters = len(users)
+	return sum
+}
+
+func writeSessionReport(sum SessionSummary) error {
+	if err := os.MkdirAll(sessionReportDir, 0755); err != nil {
+		return err
+	}
+	base := filepath.Join(sessionReportDir, "session-"+sum.ID)
+	data, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Stream session %s\n\n", sum.ID)
+	fmt.Fprintf(&b, "- Started: %s\n", sum.StartedAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "- Ended: %s\n", sum.EndedAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "- Tracks played: %d (completed %d, skipped %d, errors %d)\n", sum.TracksPlayed, sum.Completed, sum.Skipped, sum.Errors)
+	fmt.Fprintf(&b, "- Play time: %s\n", (time.Duration(sum.PlayTimeSec) * time.Second).String())
+	fmt.Fprintf(&b, "- Unique requesters: %d\n", sum.UniqueRequesters)
+	fmt.Fprintf(&b, "- Paid / free / playlist tracks: %d / %d / %d\n", sum.PaidTracks, sum.FreeTracks, sum.PlaylistTracks)
+	fmt.Fprintf(&b, "- Moderation: %d approved, %d rejected\n", sum.ModApproved, sum.ModRejected)
+	fmt.Fprintf(&b, "- Donations: %d, total %d\n", sum.Donations, sum.DonationTotal)
+	return os.WriteFile(base+".md", []byte(b.String()), 0644)
+}
+
+func (c *Cache) setSession(s Session) {
+	data, err := gobEncode(s)
+	if err != nil {
+		return
+	}
+	_ = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).Put([]byte(s.ID), data)
+	})
+}
+
+func (c *Cache) getSession(id string) (Session, bool) {
+	var s Session
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions).Get([]byte(id))
+		if b == nil {
+			return nil
+		}
+		return gobDecode(b, &s)
+	})
+	return s, s.ID != ""
+}
+
+// lastSession returns the most recently started session. IDs are start
+// timestamps with a sequence suffix for same-second starts, so key order
+// is start order.
+func (c *Cache) lastSession() (Session, bool) {
+	var s Session
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(bucketSessions).Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		return gobDecode(v, &s)
+	})
+	return s, s.ID != ""
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Rejection rules, as reported by /api/stats. They name the check in
+// validateAndAdd (or the lookup behind it) that refused a request.
+const (
+	ruleNotEmbeddable    = "not_embeddable"
+	ruleCategory         = "category"
+	ruleRegionBlocked    = "region_blocked"
+	ruleAgeRestricted    = "age_restricted"
+	ruleTooLong          = "too_long"
+	ruleLive             = "live"
+	ruleBadDuration      = "bad_duration"
+	ruleBadOffset        = "bad_offset"
+	ruleMinViews         = "min_views"
+	ruleRepeatLimit      = "repeat_limit"
+	ruleQueueFull        = "queue_full"
+	ruleUserQueueLimit   = "user_queue_limit"
+	ruleUserRequestLimit = "user_request_limit"
+	ruleNotFound         = "not_found"
+	ruleTechnical        = "technical"
+)
+
+// RequestEvent is one add attempt through validateAndAdd.
+type RequestEvent struct {
+	At       time.Time
+	VideoID  string
+	Title    string
+	User     string
+	IsPaid   bool
+	Amount   int
+	Accepted bool
+	Rule     string
+}
+
+type DonationEvent struct {
+	At     time.Time
+	Name   string
+	Amount int
+}
+
+func rejectionRule(err error) string {
+	switch {
+	case errors.Is(err, errUserQueueLimit):
+		return ruleUserQueueLimit
+	case errors.Is(err, errUserRequestLimit):
+		return ruleUserRequestLimit
+	case errors.Is(err, errCategoryNotAllowed):
+		return ruleCategory
+	case errors.Is(err, errRegionBlocked):
+		return ruleRegionBlocked
+	case errors.Is(err, errAgeRestricted):
+		return ruleAgeRestricted
+	case errors.Is(err, errLiveContent):
+		return ruleLive
+	case errors.Is(err, errBadDuration):
+		return ruleBadDuration
+	}
+	msg := err.Error()
+	for _, r := range []struct{ substr, rule string }{
+		{"not available for playback", ruleNotEmbeddable},
+		{"too long", ruleTooLong},
+		{"offset", ruleBadOffset},
+		{"insufficient views", ruleMinViews},
+		{"repeat limit", ruleRepeatLimit},
+		{"queue is full", ruleQueueFull},
+		{"video not found", ruleNotFound},
+	} {
+		if strings.Contains(msg, r.substr) {
+			return r.rule
+		}
+	}
+	return ruleTechnical
+}
+
+func (c *Cache) addEvent(bucket []byte, v any) {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucket)
+		id, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := gobEncode(v)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(seqKey(id), data)
+	})
+	if err != nil {
+		log.Printf("Event write error (%s): %v", bucket, err)
+	}
+}
+
+func (c *Cache) addRequestEvent(e RequestEvent)   { c.addEvent(bucketRequests, e) }
+func (c *Cache) addDonationEvent(e DonationEvent) { c.addEvent(bucketDonations, e) }
+
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+func (c *Cache) requestEvents(from, to time.Time) []RequestEvent {
+	var out []RequestEvent
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRequests).ForEach(func(_, v []byte) error {
+			var e RequestEvent
+			if gobDecode(v, &e) == nil && inRange(e.At, from, to) {
+				out = append(out, e)
+			}
+			return nil
+		})
+	})
+	return out
+}
+
+func (c *Cache) donationEvents(from, to time.Time) []DonationEvent {
+	var out []DonationEvent
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDonations).ForEach(func(_, v []byte) error {
+			var e DonationEvent
+			if gobDecode(v, &e) == nil && inRange(e.At, from, to) {
+				out = append(out, e)
+			}
+			return nil
+		})
+	})
+	return out
+}
+
+type VideoCount struct {
+	VideoID string `json:"video_id"`
+	Title   string `json:"title"`
+	Count   int    `json:"count"`
+}
+
+type UserCount struct {
+	User  string `json:"user"`
+	Count int    `json:"count"`
+}
+
+type DonorTotal struct {
+	User  string `json:"user"`
+	Total int    `json:"total"`
+	Count int    `json:"count"`
+}
+
+type SkipRate struct {
+	Played  int     `json:"played"`
+	Skipped int     `json:"skipped"`
+	Rate    float64 `json:"rate"`
+}
+
+type Stats struct {
+	Range          string              `json:"range"`
+	From           time.Time           `json:"from,omitzero"`
+	To             time.Time           `json:"to,omitzero"`
+	TopVideos      []VideoCount        `json:"top_videos"`
+	TopRequesters  []UserCount         `json:"top_requesters"`
+	TopDonors      []DonorTotal        `json:"top_donors"`
+	AvgTrackSec    int                 `json:"avg_track_sec"`
+	SkipRate       map[string]SkipRate `json:"skip_rate"`
+	Rejections     map[string]int      `json:"rejections"`
+	TotalRequests  int                 `json:"total_requests"`
+	TotalRejected  int                 `json:"total_rejected"`
+	TotalDonations int                 `json:"total_donations"`
+}
+
+// computeStats aggregates requests, plays and donations in [from, to).
+// Zero bounds are open. Leaderboards are cut to top entries.
+func computeStats(c *Cache, from, to time.Time, top int) Stats {
+	st := Stats{
+		From:          from,
+		To:            to,
+		TopVideos:     []VideoCount{},
+		TopRequesters: []UserCount{},
+		TopDonors:     []DonorTotal{},
+		SkipRate:      map[string]SkipRate{},
+		Rejections:    map[string]int{},
+	}
+
+	videos := map[string]*VideoCount{}
+	users := map[string]*UserCount{}
+	for _, e := range c.requestEvents(from, to) {
+		st.TotalRequests++
+		if !e.Accepted {
+			st.TotalRejected++
+			st.Rejections[e.Rule]++
+			continue
+		}
+		v := videos[e.VideoID]
+		if v == nil {
+			v = &VideoCount{VideoID: e.VideoID, Title: e.Title}
+			videos[e.VideoID] = v
+		}
+		v.Count++
+		key := strings.ToLower(e.User)
+		u := users[key]
+		if u == nil {
+			u = &UserCount{User: e.User}
+			users[key] = u
+		}
+		u.Count++
+	}
+	for _, v := range videos {
+		st.TopVideos = append(st.TopVideos, *v)
+	}
+	sort.Slice(st.TopVideos, func(i, j int) bool { return st.TopVideos[i].Count > st.TopVideos[j].Count })
+	for _, u := range users {
+		st.TopRequesters = append(st.TopRequesters, *u)
+	}
+	sort.Slice(st.TopRequesters, func(i, j int) bool { return st.TopRequesters[i].Count > st.TopRequesters[j].Count })
+
+	donors := map[string]*DonorTotal{}
+	for _, e := range c.donationEvents(from, to) {
+		st.TotalDonations += e.Amount
+		key := strings.ToLower(e.Name)
+		d := donors[key]
+		if d == nil {
+			d = &DonorTotal{User: e.Name}
+			donors[key] = d
+		}
+		d.Total += e.Amount
+		d.Count++
+	}
+	for _, d := range donors {
+		st.TopDonors = append(st.TopDonors, *d)
+	}
+	sort.Slice(st.TopDonors, func(i, j int) bool { return st.TopDonors[i].Total > st.TopDonors[j].Total })
+
+	plays, _ := c.queryHistory(HistoryFilter{From: from, To: to}, 0, 0)
+	totalSec := 0
+	for _, e := range plays {
+		totalSec += e.DurationSec
+		sr := st.SkipRate[e.Source]
+		sr.Played++
+		if e.Outcome == outcomeSkipped {
+			sr.Skipped++
+		}
+		st.SkipRate[e.Source] = sr
+	}
+	if len(plays) > 0 {
+		st.AvgTrackSec = totalSec / len(plays)
+	}
+	for src, sr := range st.SkipRate {
+		sr.Rate = float64(sr.Skipped) / float64(sr.Played)
+		st.SkipRate[src] = sr
+	}
+
+	if top > 0 {
+		st.TopVideos = st.TopVideos[:min(top, len(st.TopVideos))]
+		st.TopRequesters = st.TopRequesters[:min(top, len(st.TopRequesters))]
+		st.TopDonors = st.TopDonors[:min(top, len(st.TopDonors))]
+	}
+	return st
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultTitleStripPatterns remove the decorations uploaders add around
+// the artist and song: "(Official Music Video)", "[HD]", "| Lyrics",
+// "- Official Audio", trailing hashtags. Matching is case-insensitive.
+var defaultTitleStripPatterns = []string{
+	`[(\[【][^)\]】]*\b(?:official|video|audio|lyrics?|visuali[sz]er|hd|hq|4k|1080p|remaster(?:ed)?|mv|m/v|explicit|color coded)\b[^)\]】]*[)\]】]`,
+	`\s+[|｜].*$`,
+	`\s+-\s+(?:official(?:\s+music|\s+lyric)?\s+(?:video|audio)|lyrics?|lyric\s+video)\s*$`,
+	`(?:\s+#[^\s#]+)+\s*$`,
+}
+
+var (
+	featBracketRegex = regexp.MustCompile(`(?i)[(\[]\s*(?:feat\.?|ft\.?|featuring)\s+([^)\]]+?)\s*[)\]]`)
+	featTailRegex    = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+(.+)$`)
+	featSplitRegex   = regexp.MustCompile(`\s*(?:,|\s&\s)\s*`)
+	spaceRunRegex    = regexp.MustCompile(`\s+`)
+)
+
+var defaultTitleNormalizer = mustTitleNormalizer(nil)
+
+// TrackTitle is a video title split into the parts overlays and chat
+// bots want, next to the title as uploaded.
+type TrackTitle struct {
+	Raw string `json:"raw"`
+	// Clean is "Artist - Song" with the noise and featured artists
+	// removed.
+	Clean    string   `json:"clean"`
+	Artist   string   `json:"artist"`
+	Song     string   `json:"song"`
+	Featured []string `json:"featured,omitempty"`
+}
+
+// TitleNormalizer cleans up video titles with the default strip patterns
+// plus any configured ones.
+type TitleNormalizer struct {
+	strip []*regexp.Regexp
+}
+
+func newTitleNormalizer(extra []string) (*TitleNormalizer, error) {
+	n := &TitleNormalizer{}
+	for _, p := range append(append([]string{}, defaultTitleStripPatterns...), extra...) {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid title strip pattern %q: %w", p, err)
+		}
+		n.strip = append(n.strip, re)
+	}
+	return n, nil
+}
+
+func mustTitleNormalizer(extra []string) *TitleNormalizer {
+	n, err := newTitleNormalizer(extra)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// normalize splits raw into artist and song on " - ", falling back to the
+// channel name (without the " - Topic" suffix of YouTube's auto-generated
+// artist channels) for the artist. Featured artists are taken from
+// "(feat. X)" groups and from trailing "ft. X" in either part.
+func (n *TitleNormalizer) normalize(raw, channel string) TrackTitle {
+	tt := TrackTitle{Raw: raw}
+	s := raw
+	for _, m := range featBracketRegex.FindAllStringSubmatch(s, -1) {
+		tt.Featured = append(tt.Featured, splitFeatured(m[1])...)
+	}
+	s = featBracketRegex.ReplaceAllString(s, " ")
+	for _, re := range n.strip {
+		s = re.ReplaceAllString(s, " ")
+	}
+	s = strings.TrimSpace(spaceRunRegex.ReplaceAllString(s, " "))
+
+	artist, song, ok := strings.Cut(s, " - ")
+	if !ok {
+		artist, song = "", s
+	}
+	artist = tt.takeFeatured(artist)
+	song = tt.takeFeatured(song)
+	if artist == "" {
+		artist = strings.TrimSuffix(channel, " - Topic")
+	}
+	if song == "" {
+		// Everything was noise; better the raw title than nothing.
+		song = raw
+	}
+	tt.Artist, tt.Song = artist, song
+	tt.Clean = song
+	if ok && artist != "" {
+		tt.Clean = artist + " - " + song
+	}
+	return tt
+}
+
+// takeFeatured moves a trailing "ft. X" out of part into tt.Featured.
+func (tt *TrackTitle) takeFeatured(part string) string {
+	part = strings.Trim(part, " -:|")
+	if m := featTailRegex.FindStringSubmatchIndex(part); m != nil {
+		tt.Featured = append(tt.Featured, splitFeatured(part[m[2]:m[3]])...)
+		part = strings.TrimSpace(part[:m[0]])
+	}
+	return part
+}
+
+func splitFeatured(s string) []string {
+	var out []string
+	for _, name := range featSplitRegex.Split(s, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Titles as uploaders write them.
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		raw, channel string
+		wantClean    string
+		wantArtist   string
+		wantSong     string
+		wantFeatured []string
+	}{
+		{"Rick Astley - Never Gonna Give You Up (Official Music Video)", "Rick Astley",
+			"Rick Astley - Never Gonna Give You Up", "Rick Astley", "Never Gonna Give You Up", nil},
+		{"Daft Punk - Get Lucky [HD]", "Daft Punk",
+			"Daft Punk - Get Lucky", "Daft Punk", "Get Lucky", nil},
+		{"Adele - Hello | Lyrics", "7clouds",
+			"Adele - Hello", "Adele", "Hello", nil},
+		{"Queen - Bohemian Rhapsody (Remastered 2011)", "Queen Official",
+			"Queen - Bohemian Rhapsody", "Queen", "Bohemian Rhapsody", nil},
+		{"Coldplay - Yellow - Official Audio", "Coldplay",
+			"Coldplay - Yellow", "Coldplay", "Yellow", nil},
+		{"Linkin Park - Numb 【Official Video】", "Linkin Park",
+			"Linkin Park - Numb", "Linkin Park", "Numb", nil},
+		{"Imagine Dragons - Believer (Official Video) #shorts #music", "ImagineDragons",
+			"Imagine Dragons - Believer", "Imagine Dragons", "Believer", nil},
+
+		// Featured artists.
+		{"Calvin Harris - This Is What You Came For (feat. Rihanna)", "CalvinHarrisVEVO",
+			"Calvin Harris - This Is What You Came For", "Calvin Harris", "This Is What You Came For", []string{"Rihanna"}},
+		{"Mark Ronson - Uptown Funk ft. Bruno Mars (Official Video)", "Mark Ronson",
+			"Mark Ronson - Uptown Funk", "Mark Ronson", "Uptown Funk", []string{"Bruno Mars"}},
+		{"DJ Khaled ft. Drake, Lil Wayne & Rick Ross - I'm On One", "DJ Khaled",
+			"DJ Khaled - I'm On One", "DJ Khaled", "I'm On One", []string{"Drake", "Lil Wayne", "Rick Ross"}},
+		{"Eminem - Love The Way You Lie [featuring Rihanna]", "EminemVEVO",
+			"Eminem - Love The Way You Lie", "Eminem", "Love The Way You Lie", []string{"Rihanna"}},
+
+		// No artist in the title: the channel stands in.
+		{"Bohemian Rhapsody (Remastered 2011)", "Queen - Topic",
+			"Bohemian Rhapsody", "Queen", "Bohemian Rhapsody", nil},
+		{"Never Gonna Give You Up", "",
+			"Never Gonna Give You Up", "", "Never Gonna Give You Up", nil},
+		// Nothing left after stripping: keep the raw title.
+		{"(Official Video)", "Someone",
+			"(Official Video)", "Someone", "(Official Video)", nil},
+	}
+	for _, tt := range tests {
+		got := defaultTitleNormalizer.normalize(tt.raw, tt.channel)
+		if got.Raw != tt.raw || got.Clean != tt.wantClean || got.Artist != tt.wantArtist ||
+			got.Song != tt.wantSong || !slices.Equal(got.Featured, tt.wantFeatured) {
+			t.Errorf("normalize(%q, %q) = {%q, %q, %q, %q}, want {%q, %q, %q, %q}",
+				tt.raw, tt.channel, got.Clean, got.Artist, got.Song, got.Featured,
+				tt.wantClean, tt.wantArtist, tt.wantSong, tt.wantFeatured)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VideoRef is a video ID plus the optional part of it to play, in seconds.
+// EndSec 0 means play to the end.
+type VideoRef struct {
+	ID       string
+	StartSec int
+	EndSec   int
+}
+
+// youtubeLinkRegex finds YouTube links anywhere in free text, with or
+// without a scheme. The host alternatives are listed explicitly so that
+// text glued to the front of a link ("смотриyoutube.com/...") is not
+// taken as part of the host.
+var youtubeLinkRegex = regexp.MustCompile(
+	`(?i)(?:https?://)?(?:(?:www|m|music|gaming)\.)?(?:youtube\.com|youtube-nocookie\.com|youtu\.be)/[^\s<>"'` + "`" + `]*`,
+)
+
+var videoIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// Path prefixes that are followed directly by a video ID.
+var videoIDPathPrefixes = []string{"/shorts/", "/embed/", "/live/", "/v/", "/e/", "/watch/"}
+
+// extractVideoRef finds the video in a bare ID, a YouTube link, or free
+// text (donation messages) containing a link. Start and end offsets are
+// taken from t=, start= and end= in the link. ID is "" when nothing usable
+// is found. Links without a video (channels, playlists) are skipped in
+// favour of the next link in the text. The real-world inputs it accepts
+// are listed in TestExtractVideoRef.
+func extractVideoRef(text string) VideoRef {
+	text = strings.TrimSpace(text)
+	if videoIDRegex.MatchString(text) {
+		return VideoRef{ID: text}
+	}
+	for _, link := range youtubeLinkRegex.FindAllString(text, -1) {
+		if ref := videoRefFromLink(link); ref.ID != "" {
+			return ref
+		}
+	}
+	return VideoRef{}
+}
+
+// videoRefFromLink parses a single YouTube link found by youtubeLinkRegex.
+func videoRefFromLink(link string) VideoRef {
+	link = strings.ReplaceAll(link, "&amp;", "&")
+	link = strings.TrimRight(link, ".,;:!?)]}»\"'")
+	if !strings.Contains(strings.ToLower(link[:min(len(link), 8)]), "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return VideoRef{}
+	}
+	q := u.Query()
+	ref := VideoRef{ID: videoIDFromURL(u, q)}
+	if ref.ID == "" {
+		return ref
+	}
+	// Old share links put the time in the fragment: #t=1m30s.
+	frag, _ := url.ParseQuery(u.Fragment)
+	for _, v := range []string{q.Get("t"), q.Get("start"), frag.Get("t")} {
+		if sec, ok := parseOffset(v); ok {
+			ref.StartSec = sec
+			break
+		}
+	}
+	ref.EndSec, _ = parseOffset(q.Get("end"))
+	return ref
+}
+
+func videoIDFromURL(u *url.URL, q url.Values) string {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if host == "youtu.be" {
+		return leadingVideoID(strings.TrimPrefix(u.Path, "/"))
+	}
+	path := u.Path
+	switch {
+	case path == "/watch" || path == "/watch/":
+		return leadingVideoID(q.Get("v"))
+	case path == "/attribution_link":
+		// u holds a site-relative link such as /watch?v=ID&feature=share.
+		if inner := q.Get("u"); strings.HasPrefix(inner, "/") {
+			return videoRefFromLink("youtube.com" + inner).ID
+		}
+		return ""
+	}
+	for _, prefix := range videoIDPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return leadingVideoID(strings.TrimPrefix(path, prefix))
+		}
+	}
+	return ""
+}
+
+var offsetUnitsRegex = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+
+// maxOffsetSec bounds offsets well past the longest video so that absurd
+// values are rejected instead of overflowing.
+const maxOffsetSec = 7 * 24 * 3600
+
+// parseOffset reads a time offset in any of the forms YouTube links use:
+// "90", "90s", "1m30s", "1h2m3s", "1:30" or "1:02:03".
+func parseOffset(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, false
+	}
+	if strings.Contains(s, ":") {
+		sec := 0
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || n > maxOffsetSec {
+				return 0, false
+			}
+			if sec = sec*60 + n; sec > maxOffsetSec {
+				return 0, false
+			}
+		}
+		return sec, true
+	}
+	m := offsetUnitsRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	sec := 0
+	for i, mul := range []int{3600, 60, 1} {
+		if m[i+1] != "" {
+			n, err := strconv.Atoi(m[i+1])
+			if err != nil || n > maxOffsetSec {
+				return 0, false
+			}
+			sec += n * mul
+		}
+	}
+	if sec > maxOffsetSec {
+		return 0, false
+	}
+	return sec, true
+}
+
+// leadingVideoID returns the video ID at the start of s when it is not
+// followed by more ID characters. Donation text often has words or
+// punctuation glued straight onto the end of a link.
+func leadingVideoID(s string) string {
+	if len(s) < 11 || !videoIDRegex.MatchString(s[:11]) {
+		return ""
+	}
+	if len(s) > 11 && isVideoIDChar(s[11]) {
+		return ""
+	}
+	return s[:11]
+}
+
+func isVideoIDChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+// Real-world inputs seen in chat and donation messages.
+func TestExtractVideoRef(t *testing.T) {
+	const id = "dQw4w9WgXcQ"
+	tests := []struct {
+		input     string
+		wantID    string
+		wantStart int
+		wantEnd   int
+	}{
+		{"dQw4w9WgXcQ", id, 0, 0},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", id, 0, 0},
+		{"https://youtube.com/watch?v=dQw4w9WgXcQ&list=RDdQw4w9WgXcQ&index=1", id, 0, 0},
+		{"https://www.youtube.com/watch?feature=shared&v=dQw4w9WgXcQ", id, 0, 0},
+		{"https://www.youtube.com/watch?app=desktop&v=dQw4w9WgXcQ&t=42s", id, 42, 0},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", id, 0, 0},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&si=AbCdEfGh", id, 0, 0},
+		{"https://youtu.be/dQw4w9WgXcQ", id, 0, 0},
+		{"https://youtu.be/dQw4w9WgXcQ?si=AbCdEfGhIjKl&t=10", id, 10, 0},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", id, 0, 0},
+		{"https://youtube.com/shorts/dQw4w9WgXcQ?feature=share", id, 0, 0},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?autoplay=1", id, 0, 0},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", id, 0, 0},
+		{"https://www.youtube.com/live/dQw4w9WgXcQ?si=AbCdEfGh", id, 0, 0},
+		{"https://www.youtube.com/v/dQw4w9WgXcQ?version=3", id, 0, 0},
+		{"https://www.youtube.com/attribution_link?u=%2Fwatch%3Fv%3DdQw4w9WgXcQ%26feature%3Dshare", id, 0, 0},
+		{"www.youtube.com/watch?v=dQw4w9WgXcQ", id, 0, 0},
+		{"youtu.be/dQw4w9WgXcQ", id, 0, 0},
+		{"HTTPS://WWW.YOUTUBE.COM/watch?v=dQw4w9WgXcQ", id, 0, 0},
+		{"https://www.youtube.com/watch?feature=share&amp;v=dQw4w9WgXcQ", id, 0, 0},
+		{"Поставь пожалуйста https://youtu.be/dQw4w9WgXcQ!!!", id, 0, 0},
+		{"трек:youtu.be/dQw4w9WgXcQ, спасибо", id, 0, 0},
+		{"(https://www.youtube.com/watch?v=dQw4w9WgXcQ)", id, 0, 0},
+		{"«https://music.youtube.com/watch?v=dQw4w9WgXcQ».", id, 0, 0},
+		{"https://youtu.be/dQw4w9WgXcQспасибо за стрим", id, 0, 0},
+		// Links without a video are skipped in favour of the next one.
+		{"привет! https://youtube.com/channel/UC123 и https://youtu.be/dQw4w9WgXcQ", id, 0, 0},
+
+		// Offsets.
+		{"https://youtu.be/dQw4w9WgXcQ?t=90", id, 90, 0},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1m30s", id, 90, 0},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=1:30", id, 90, 0},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?start=90&end=150", id, 90, 150},
+		// Out-of-range offsets are dropped, the video is kept.
+		{"https://youtu.be/dQw4w9WgXcQ?t=99999999999999999999s", id, 0, 0},
+		{"https://youtu.be/dQw4w9WgXcQ?t=9999999999999999h", id, 0, 0},
+		{"https://youtu.be/dQw4w9WgXcQ?t=1:99999999999999999999", id, 0, 0},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?start=90&end=99999999999", id, 90, 0},
+
+		// Nothing usable.
+		{"", "", 0, 0},
+		{"просто текст", "", 0, 0},
+		{"https://www.youtube.com/playlist?list=PL0123456789", "", 0, 0},
+		{"https://youtu.be/dQw4w9WgXcQabc", "", 0, 0},
+	}
+	for _, tt := range tests {
+		got := extractVideoRef(tt.input)
+		if got.ID != tt.wantID || got.StartSec != tt.wantStart || got.EndSec != tt.wantEnd {
+			t.Errorf("extractVideoRef(%q) = {%q, %d, %d}, want {%q, %d, %d}",
+				tt.input, got.ID, got.StartSec, got.EndSec, tt.wantID, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
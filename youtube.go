@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,143 +23,584 @@ type VideoInfo struct {
 	Duration   int
 	Views      int
 	Embeddable bool
+	CategoryId string
+	// Broadcast is snippet.liveBroadcastContent: "live", "upcoming" or "none".
+	Broadcast string
+	// Stale is set when the info comes from an expired cache entry
+	// because the API could not be reached.
+	Stale bool
+	Restrictions
+	VideoMeta
 }
 
+// VideoMeta is descriptive metadata shown with a track: the uploading
+// channel, cover art and publish date.
+type VideoMeta struct {
+	ChannelTitle string    `json:"channel_title,omitempty"`
+	ChannelID    string    `json:"channel_id,omitempty"`
+	Thumbnail    string    `json:"thumbnail,omitempty"`
+	PublishedAt  time.Time `json:"published_at,omitzero"`
+}
+
+// MetadataProvider resolves video and playlist metadata. YouTubeClient
+// talks to the Data API; FakeProvider serves fixtures for offline testing.
+// Cancelling ctx abandons a call, retries included.
+type MetadataProvider interface {
+	// getVideoInfo resolves one video. Category rules are left to the
+	// caller (see CategoryPolicy).
+	getVideoInfo(ctx context.Context, vid string) (VideoInfo, error)
+	// getVideoInfoBatch resolves many videos at once. Missing videos are
+	// absent from the result.
+	getVideoInfoBatch(ctx context.Context, vids []string) (map[string]VideoInfo, error)
+	// playlistVideoIDs lists every video ID in a playlist, in order.
+	playlistVideoIDs(ctx context.Context, pid string) ([]string, error)
+	// search finds music videos matching free text, best match first.
+	// Essential searches (donations) may spend the quota reserve.
+	search(ctx context.Context, query string, limit int, essential bool) ([]SearchResult, error)
+}
+
+const defaultYouTubeAPIBaseURL = "https://www.googleapis.com/youtube/v3"
+
+const (
+	// ytAttemptTimeout bounds a single HTTP attempt, body included.
+	ytAttemptTimeout = 10 * time.Second
+	ytMaxAttempts    = 3
+	ytRetryBase      = 500 * time.Millisecond
+
+	// The breaker opens after ytBreakerThreshold calls in a row fail
+	// all their attempts, and probes again after ytBreakerCooldown.
+	ytBreakerThreshold = 5
+	ytBreakerCooldown  = 30 * time.Second
+
+	staleRevalidateInterval = time.Minute
+)
+
+// errYouTubeUnavailable marks failures worth retrying later: the API did
+// not answer, answered with a server error, or the breaker is open.
+var errYouTubeUnavailable = errors.New("YouTube API unavailable")
+
+var errVideoNotFound = errors.New("video not found")
+
 type YouTubeClient struct {
-	apiKey string
-	cache  *Cache
+	keys    *KeyPool
+	baseURL string
+	cache   *Cache
+	quota   *QuotaTracker
+	cfg     *ConfigManager
+	client  *http.Client
+	breaker *Breaker
+
+	// stale holds videos served from expired cache entries, to be
+	// refreshed by revalidate once the API answers again.
+	staleMu sync.Mutex
+	stale   map[string]bool
+}
+
+// maxBatchIDs is the most IDs the videos endpoint accepts per call.
+const maxBatchIDs = 50
+
+type videoItem struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title                string `json:"title"`
+		CategoryId           string `json:"categoryId"`
+		LiveBroadcastContent string `json:"liveBroadcastContent"`
+		ChannelTitle         string `json:"channelTitle"`
+		ChannelId            string `json:"channelId"`
+		PublishedAt          string `json:"publishedAt"`
+		Thumbnails           map[string]struct {
+			URL string `json:"url"`
+		} `json:"thumbnails"`
+	} `json:"snippet"`
+	ContentDetails struct {
+		Duration          string `json:"duration"`
+		RegionRestriction struct {
+			Allowed []string `json:"allowed"`
+			Blocked []string `json:"blocked"`
+		} `json:"regionRestriction"`
+		ContentRating struct {
+			YtRating string `json:"ytRating"`
+		} `json:"contentRating"`
+	} `json:"contentDetails"`
+	Statistics struct {
+		ViewCount string `json:"viewCount"`
+	} `json:"statistics"`
+	Status struct {
+		Embeddable    bool   `json:"embeddable"`
+		PrivacyStatus string `json:"privacyStatus"`
+	} `json:"status"`
 }
 
-var youtubeIDRegex = regexp.MustCompile(`(?:youtube\.com/watch\?v=|youtu\.be/)([a-zA-Z0-9_-]{11})`)
+type playlistAPIResponse struct {
+	Items []struct {
+		Snippet struct {
+			ResourceID struct {
+				VideoID string `json:"videoId"`
+			} `json:"resourceId"`
+		} `json:"snippet"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
 
-func extractVideoID(text string) string {
-	if matches := youtubeIDRegex.FindStringSubmatch(text); len(matches) > 1 {
-		return matches[1]
+func newYouTubeClient(cfg *ConfigManager, c *Cache, quota *QuotaTracker) *YouTubeClient {
+	conf := cfg.get()
+	baseURL := conf.YouTubeAPIBaseURL
+	if baseURL == "" {
+		baseURL = defaultYouTubeAPIBaseURL
+	}
+	return &YouTubeClient{
+		keys:    newKeyPool(conf.apiKeys()),
+		baseURL: strings.TrimRight(baseURL, "/"),
+		cache:   c,
+		quota:   quota,
+		cfg:     cfg,
+		client:  &http.Client{Timeout: ytAttemptTimeout},
+		breaker: newBreaker("YouTube API", ytBreakerThreshold, ytBreakerCooldown),
+		stale:   make(map[string]bool),
 	}
-	if len(text) == 11 {
-		matched, _ := regexp.MatchString(`^[a-zA-Z0-9_-]{11}$`, text)
-		if matched {
-			return text
+}
+
+// getVideoInfo resolves a video from the cache or the API. When the API
+// is unavailable or out of quota, an expired cache entry (up to the
+// configured staleness) is returned instead, flagged Stale.
+func (c *YouTubeClient) getVideoInfo(ctx context.Context, vid string) (VideoInfo, error) {
+	if e, ok := c.cache.getVideo(vid); ok {
+		return e.info(), nil
+	}
+	if m, ok := c.cache.getMissing(vid); ok {
+		return VideoInfo{}, m.err()
+	}
+	info, err := c.fetchVideoInfo(ctx, vid)
+	if err != nil {
+		if stale, ok := c.staleVideo(vid, err); ok {
+			log.Printf("Serving stale metadata for %s: %v", vid, err)
+			return stale, nil
 		}
 	}
-	return ""
+	return info, err
 }
 
-func newYouTubeClient(apiKey string, c *Cache) *YouTubeClient {
-	return &YouTubeClient{apiKey: apiKey, cache: c}
+// getVideoInfoBatch resolves many videos. Cached entries are used first;
+// the rest are fetched maxBatchIDs at a time and cached. Videos that are
+// missing are left out of the result. On a request failure the videos
+// resolved so far are returned together with the error.
+func (c *YouTubeClient) getVideoInfoBatch(ctx context.Context, vids []string) (map[string]VideoInfo, error) {
+	out := make(map[string]VideoInfo, len(vids))
+	var missing []string
+	seen := make(map[string]bool, len(vids))
+	for _, vid := range vids {
+		if seen[vid] {
+			continue
+		}
+		seen[vid] = true
+		if e, ok := c.cache.getVideo(vid); ok {
+			out[vid] = e.info()
+			continue
+		}
+		if _, ok := c.cache.getMissing(vid); ok {
+			continue
+		}
+		missing = append(missing, vid)
+	}
+	for start := 0; start < len(missing); start += maxBatchIDs {
+		chunk := missing[start:min(start+maxBatchIDs, len(missing))]
+		items, err := c.fetchVideos(ctx, chunk, false)
+		if err != nil {
+			n := 0
+			for _, vid := range missing[start:] {
+				if info, ok := c.staleVideo(vid, err); ok {
+					out[vid] = info
+					n++
+				}
+			}
+			if n > 0 {
+				log.Printf("Serving stale metadata for %d videos: %v", n, err)
+			}
+			return out, err
+		}
+		returned := make(map[string]bool, len(items))
+		for _, item := range items {
+			returned[item.ID] = true
+			e, err := videoEntryFromItem(item)
+			if err != nil {
+				c.cache.setMissing(item.ID, err)
+				continue
+			}
+			c.cache.setVideo(item.ID, e)
+			out[item.ID] = e.info()
+		}
+		for _, vid := range chunk {
+			if !returned[vid] {
+				c.cache.setMissing(vid, errVideoNotFound)
+			}
+		}
+	}
+	return out, nil
 }
 
-func (c *YouTubeClient) getVideoInfo(vid string) (VideoInfo, error) {
-	return c.getVideoInfoWithClient(vid, &http.Client{Timeout: 20 * time.Second})
+// staleVideo looks up an expired cache entry for vid when err means the
+// API cannot be used right now, and queues vid for revalidation.
+func (c *YouTubeClient) staleVideo(vid string, err error) (VideoInfo, bool) {
+	if !errors.Is(err, errYouTubeUnavailable) && !errors.Is(err, errNoUsableKey) {
+		return VideoInfo{}, false
+	}
+	maxStale := c.cfg.get().cacheMaxStale()
+	if maxStale <= 0 {
+		return VideoInfo{}, false
+	}
+	e, ok := c.cache.getStaleVideo(vid, maxStale)
+	if !ok {
+		return VideoInfo{}, false
+	}
+	c.staleMu.Lock()
+	c.stale[vid] = true
+	c.staleMu.Unlock()
+	info := e.info()
+	info.Stale = true
+	return info, true
 }
 
-// getVideoInfoForce fetches video info without enforcing the music category
-// restriction. Used for moderation approvals. Embeddable is still enforced.
-func (c *YouTubeClient) getVideoInfoForce(vid string) (VideoInfo, error) {
-	if e, ok := c.cache.getVideo(vid); ok {
-		return VideoInfo{Title: e.Title, Duration: e.Duration, Views: e.Views, Embeddable: e.Embeddable}, nil
+// revalidate periodically refetches videos that were served stale. Calls
+// are non-essential and go through the breaker, so nothing is sent while
+// the API is still down. Run it in its own goroutine.
+func (c *YouTubeClient) revalidate() {
+	ticker := time.NewTicker(staleRevalidateInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.revalidateStale()
 	}
-	return c.fetchVideoInfo(vid, &http.Client{Timeout: 20 * time.Second}, true)
 }
 
-func (c *YouTubeClient) getVideoInfoWithClient(vid string, client *http.Client) (VideoInfo, error) {
-	if e, ok := c.cache.getVideo(vid); ok {
-		if e.CategoryId != "10" {
-			return VideoInfo{}, fmt.Errorf("only music videos are allowed")
+func (c *YouTubeClient) revalidateStale() {
+	c.staleMu.Lock()
+	var ids []string
+	for vid := range c.stale {
+		if len(ids) == maxBatchIDs {
+			break
 		}
-		return VideoInfo{Title: e.Title, Duration: e.Duration, Views: e.Views, Embeddable: e.Embeddable}, nil
+		ids = append(ids, vid)
+	}
+	c.staleMu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	items, err := c.fetchVideos(ctx, ids, false)
+	if err != nil {
+		return
 	}
-	return c.fetchVideoInfo(vid, client, false)
+	fresh := make(map[string]bool, len(items))
+	for _, item := range items {
+		e, err := videoEntryFromItem(item)
+		if err != nil {
+			c.cache.setMissing(item.ID, err)
+			continue
+		}
+		c.cache.setVideo(item.ID, e)
+		fresh[item.ID] = true
+	}
+	c.staleMu.Lock()
+	for _, vid := range ids {
+		delete(c.stale, vid)
+		// Gone or no longer valid: stop serving the old entry.
+		if !fresh[vid] {
+			c.cache.deleteVideo(vid)
+			if _, ok := c.cache.getMissing(vid); !ok {
+				c.cache.setMissing(vid, errVideoNotFound)
+			}
+		}
+	}
+	c.staleMu.Unlock()
+	log.Printf("Revalidated %d stale videos (%d refreshed)", len(ids), len(fresh))
+}
+
+func (c *YouTubeClient) stalePending() int {
+	c.staleMu.Lock()
+	defer c.staleMu.Unlock()
+	return len(c.stale)
 }
 
-func (c *YouTubeClient) fetchVideoInfo(vid string, client *http.Client, skipCategory bool) (VideoInfo, error) {
-	if c.apiKey == "" {
-		return VideoInfo{}, fmt.Errorf("YouTube API key not configured")
+// fetchVideoInfo looks a video up through the API. Failures that belong
+// to the video itself (not found, unusable metadata) are cached for
+// missingTTL; request-level errors are not.
+func (c *YouTubeClient) fetchVideoInfo(ctx context.Context, vid string) (VideoInfo, error) {
+	e, err := c.fetchVideoEntry(ctx, vid)
+	if err != nil {
+		c.cache.setMissing(vid, err)
+		return VideoInfo{}, err
+	}
+	c.cache.setVideo(vid, e)
+	return e.info(), nil
+}
+
+func (c *YouTubeClient) fetchVideoEntry(ctx context.Context, vid string) (VideoEntry, error) {
+	items, err := c.fetchVideos(ctx, []string{vid}, true)
+	if err != nil {
+		return VideoEntry{}, err
+	}
+	if len(items) == 0 {
+		return VideoEntry{}, errVideoNotFound
 	}
-	url := fmt.Sprintf(
-		"https://www.googleapis.com/youtube/v3/videos?part=snippet,contentDetails,statistics,status&id=%s&key=%s",
-		vid, c.apiKey,
+	return videoEntryFromItem(items[0])
+}
+
+// get performs an API call costing units of quota. Non-essential calls
+// are refused once only the configured reserve is left. The API key is
+// appended here; when a key is out of quota or rejected the call is
+// retried with the next one. Network errors, 5xx responses and rate
+// limits (429, or 403 rateLimitExceeded) are retried up to ytMaxAttempts
+// times and then fail with errYouTubeUnavailable, which also counts
+// against the circuit breaker.
+func (c *YouTubeClient) get(ctx context.Context, u string, units int, essential bool) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errYouTubeUnavailable, err)
+	}
+	resp, err := c.getWithRetry(ctx, u, units, essential)
+	switch {
+	case err == nil:
+		c.breaker.success()
+	case errors.Is(err, errYouTubeUnavailable):
+		c.breaker.failure(err)
+	default:
+		c.breaker.abort()
+	}
+	return resp, err
+}
+
+func (c *YouTubeClient) getWithRetry(ctx context.Context, u string, units int, essential bool) (*http.Response, error) {
+	attempts := 0
+	for {
+		idx, key, err := c.keys.pick()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.quota.allow(units, essential); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u+"&key="+url.QueryEscape(key), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.client.Do(req)
+		var failure error
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			failure = err
+		case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+			resp.Body.Close()
+			c.quota.spend(units)
+			c.keys.spend(idx, units)
+			failure = fmt.Errorf("status %d", resp.StatusCode)
+		case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusForbidden:
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			reason := apiErrorReason(body)
+			if status := keyStatusForReason(reason); status != "" {
+				log.Printf("YouTube API key %s %s (%s), rotating", maskKey(key), status, reason)
+				c.keys.markBad(idx, status, reason)
+				continue
+			}
+			c.quota.spend(units)
+			c.keys.spend(idx, units)
+			if isRateLimitReason(reason) {
+				failure = fmt.Errorf("status %d (%s)", resp.StatusCode, reason)
+				break
+			}
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return resp, nil
+		default:
+			c.quota.spend(units)
+			c.keys.spend(idx, units)
+			return resp, nil
+		}
+		attempts++
+		if attempts >= ytMaxAttempts {
+			return nil, fmt.Errorf("%w after %d attempts: %v", errYouTubeUnavailable, attempts, failure)
+		}
+		delay := retryDelay(attempts)
+		log.Printf("YouTube API call failed (%v), retrying in %s", failure, delay.Round(time.Millisecond))
+		if err := sleepCtx(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay is the wait before retry n (from 1): exponential backoff
+// from ytRetryBase with jitter, so concurrent callers spread out.
+func retryDelay(n int) time.Duration {
+	d := ytRetryBase << (n - 1)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (c *YouTubeClient) keyHealth() []KeyHealth { return c.keys.health() }
+
+func (c *YouTubeClient) breakerStatus() BreakerStatus { return c.breaker.status() }
+
+// fetchVideos calls the videos endpoint for up to maxBatchIDs IDs.
+// Batch lookups (playlists) are non-essential for quota purposes.
+func (c *YouTubeClient) fetchVideos(ctx context.Context, ids []string, essential bool) ([]videoItem, error) {
+	u := fmt.Sprintf(
+		"%s/videos?part=snippet,contentDetails,statistics,status&id=%s",
+		c.baseURL, strings.Join(ids, ","),
 	)
-	resp, err := client.Get(url)
+	resp, err := c.get(ctx, u, quotaCostList, essential)
 	if err != nil {
-		return VideoInfo{}, fmt.Errorf("failed to fetch video info: %w", err)
+		return nil, fmt.Errorf("failed to fetch video info: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return VideoInfo{}, fmt.Errorf("youtube API returned status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("youtube API returned status: %d", resp.StatusCode)
 	}
 	var apiResp struct {
-		Items []struct {
-			Snippet struct {
-				Title      string `json:"title"`
-				CategoryId string `json:"categoryId"`
-			} `json:"snippet"`
-			ContentDetails struct {
-				Duration string `json:"duration"`
-			} `json:"contentDetails"`
-			Statistics struct {
-				ViewCount string `json:"viewCount"`
-			} `json:"statistics"`
-			Status struct {
-				Embeddable    bool   `json:"embeddable"`
-				PrivacyStatus string `json:"privacyStatus"`
-			} `json:"status"`
-		} `json:"items"`
+		Items []videoItem `json:"items"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return VideoInfo{}, fmt.Errorf("failed to parse API response: %w", err)
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
 	}
-	if len(apiResp.Items) == 0 {
-		return VideoInfo{}, fmt.Errorf("video not found")
-	}
-	item := apiResp.Items[0]
+	return apiResp.Items, nil
+}
+
+func videoEntryFromItem(item videoItem) (VideoEntry, error) {
 	dur, err := parseISO8601Duration(item.ContentDetails.Duration)
 	if err != nil {
-		return VideoInfo{}, fmt.Errorf("failed to parse duration: %w", err)
+		return VideoEntry{}, fmt.Errorf("%w: %v", errBadDuration, err)
 	}
 	views := 0
 	if item.Statistics.ViewCount != "" {
 		views, _ = strconv.Atoi(item.Statistics.ViewCount)
 	}
-	if !skipCategory && item.Snippet.CategoryId != "10" {
-		return VideoInfo{}, fmt.Errorf("only music videos are allowed")
-	}
-	info := VideoInfo{
+	e := VideoEntry{
 		Title:      item.Snippet.Title,
 		Duration:   dur,
 		Views:      views,
 		Embeddable: item.Status.Embeddable && item.Status.PrivacyStatus == "public",
+		CategoryId: item.Snippet.CategoryId,
+		Broadcast:  item.Snippet.LiveBroadcastContent,
+		Restrictions: Restrictions{
+			RegionAllowed: item.ContentDetails.RegionRestriction.Allowed,
+			RegionBlocked: item.ContentDetails.RegionRestriction.Blocked,
+			AgeRestricted: item.ContentDetails.ContentRating.YtRating == "ytAgeRestricted",
+		},
+		VideoMeta: VideoMeta{
+			ChannelTitle: item.Snippet.ChannelTitle,
+			ChannelID:    item.Snippet.ChannelId,
+		},
+		TTL: videoTTL,
 	}
-	ttl := videoTTL
-	if !info.Embeddable {
-		ttl = videoTTLBlocked
+	// Cover art: the largest size every video has.
+	for _, size := range []string{"high", "medium", "default"} {
+		if th, ok := item.Snippet.Thumbnails[size]; ok && th.URL != "" {
+			e.Thumbnail = th.URL
+			break
+		}
 	}
-	c.cache.setVideo(vid, VideoEntry{
-		Title:      info.Title,
-		Duration:   info.Duration,
-		Views:      info.Views,
-		Embeddable: info.Embeddable,
-		CategoryId: item.Snippet.CategoryId,
-		TTL:        ttl,
-	})
-	return info, nil
+	e.PublishedAt, _ = time.Parse(time.RFC3339, item.Snippet.PublishedAt)
+	switch {
+	case e.Broadcast == broadcastLive || e.Broadcast == broadcastUpcoming:
+		// Live and upcoming videos change state soon; cache them briefly.
+		e.TTL = videoTTLLive
+	case !e.Embeddable:
+		e.TTL = videoTTLBlocked
+	}
+	return e, nil
 }
 
+func (c *YouTubeClient) playlistVideoIDs(ctx context.Context, pid string) ([]string, error) {
+	var vids []string
+	pageToken := ""
+	for {
+		u := fmt.Sprintf(
+			"%s/playlistItems?part=snippet&playlistId=%s&maxResults=50",
+			c.baseURL, pid,
+		)
+		if pageToken != "" {
+			u += "&pageToken=" + pageToken
+		}
+		page, err := c.fetchPlaylistPage(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if vid := item.Snippet.ResourceID.VideoID; vid != "" {
+				vids = append(vids, vid)
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	return vids, nil
+}
+
+func (c *YouTubeClient) fetchPlaylistPage(ctx context.Context, u string) (*playlistAPIResponse, error) {
+	resp, err := c.get(ctx, u, quotaCostList, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("youtube API returned status: %d", resp.StatusCode)
+	}
+	var ar playlistAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return &ar, nil
+}
+
+// iso8601DurationRegex matches PnYnMnWnDTnHnMnS with every component
+// optional. Seconds may be fractional ("PT1.5S", "PT1,5S").
+var iso8601DurationRegex = regexp.MustCompile(
+	`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)(?:[.,]\d+)?S)?)?$`,
+)
+
+// parseISO8601Duration converts an ISO-8601 duration to whole seconds.
+// Weeks and days are accepted (long streams report "P1DT2H"); years and
+// months are rejected because they have no fixed length. "P0D", which the
+// API reports for live streams and premieres, parses as 0.
 func parseISO8601Duration(iso string) (int, error) {
-	re := regexp.MustCompile(`PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?`)
-	matches := re.FindStringSubmatch(iso)
-	if len(matches) == 0 {
-		return 0, fmt.Errorf("invalid duration format")
+	m := iso8601DurationRegex.FindStringSubmatch(iso)
+	if m == nil || iso == "P" || strings.HasSuffix(iso, "T") {
+		return 0, fmt.Errorf("invalid ISO-8601 duration %q", iso)
 	}
-	h, m, s := 0, 0, 0
-	if matches[1] != "" {
-		h, _ = strconv.Atoi(matches[1])
+	var n [7]int
+	for i, s := range m[1:] {
+		if s == "" {
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("ISO-8601 duration %q out of range", iso)
+		}
+		n[i] = v
 	}
-	if matches[2] != "" {
-		m, _ = strconv.Atoi(matches[2])
+	if n[0] > 0 || n[1] > 0 {
+		return 0, fmt.Errorf("ISO-8601 duration %q uses years or months, which have no fixed length", iso)
 	}
-	if matches[3] != "" {
-		s, _ = strconv.Atoi(matches[3])
+	const maxSec = 1<<31 - 1
+	total := 0
+	for i, unit := range []int{7 * 86400, 86400, 3600, 60, 1} {
+		v := n[i+2]
+		if v > (maxSec-total)/unit {
+			return 0, fmt.Errorf("ISO-8601 duration %q out of range", iso)
+		}
+		total += v * unit
 	}
-	return h*3600 + m*60 + s, nil
+	return total, nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+// Durations as the videos endpoint reports them.
+func TestParseISO8601Duration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"PT3M30S", 210, false},
+		{"PT45S", 45, false},
+		{"PT1H2M3S", 3723, false},
+		{"PT2H", 7200, false},
+		{"PT10M", 600, false},
+		{"PT1.5S", 1, false},
+		{"PT1,5S", 1, false},
+		// Long streams run into days and weeks.
+		{"P1DT2H", 93600, false},
+		{"P1D", 86400, false},
+		{"P1W", 604800, false},
+		{"P1W2DT3H4M5S", 788645, false},
+		// Live streams and premieres.
+		{"P0D", 0, false},
+		{"PT0S", 0, false},
+
+		// Unusable.
+		{"", 0, true},
+		{"P", 0, true},
+		{"PT", 0, true},
+		{"P1DT", 0, true},
+		{"3M30S", 0, true},
+		{"PT3M30", 0, true},
+		{"PT-5S", 0, true},
+		{"P1Y", 0, true},
+		{"P2M", 0, true},
+		{"P1Y0M0DT1H", 0, true},
+		{"PT99999999999999999999S", 0, true},
+		{"P9999999W", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseISO8601Duration(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseISO8601Duration(%q) = %d, %v; want %d, error %v",
+				tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}